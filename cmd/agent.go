@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/yoshino-s/derperer/internal/agent"
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+)
+
+var (
+	agentApp = agent.New()
+	agentCmd = &cobra.Command{
+		Use:   "agent",
+		Short: "Check the endpoints of a central derperer and report the results",
+		Run: func(cmd *cobra.Command, args []string) {
+			app.Append(speedtest.New())
+			app.Append(agentApp)
+
+			app.Go(context.Background())
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentApp.Configuration().Register(agentCmd.Flags())
+}
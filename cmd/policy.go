@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	policyApp = newPolicyCmdApp()
+	policyCmd = &cobra.Command{
+		Use:   "policy",
+		Short: "Export the DERP map of a derperer as the derpMap block of a Tailscale policy file",
+		Run: func(cmd *cobra.Command, args []string) {
+			app.Append(policyApp)
+
+			app.Go(context.Background())
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyApp.Configuration().Register(policyCmd.Flags())
+}
+
+type policyCmdApp struct {
+	*application.EmptyApplication
+	config policyCmdConfig
+}
+
+type policyCmdConfig struct {
+	Server             string `mapstructure:"server"`
+	Query              string `mapstructure:"query"`
+	OmitDefaultRegions bool   `mapstructure:"omit_default_regions"`
+	File               string `mapstructure:"file"`
+	Write              bool   `mapstructure:"write"`
+}
+
+func (p *policyCmdConfig) Read() {
+	utils.MustDecodeFromMapstructure(viper.AllSettings()["policy"], p)
+}
+
+func (p *policyCmdConfig) Register(set *pflag.FlagSet) {
+	set.String("policy.server", "", "URL of the derperer to export the DERP map of")
+	set.String("policy.query", "", "Query parameters of /derp.json to filter the DERP map, e.g. status=available&group=country")
+	set.Bool("policy.omit_default_regions", false, "Only use the exported regions instead of Tailscale's")
+	set.String("policy.file", "", "HuJSON policy file to merge the derpMap block into, empty to print the block only")
+	set.Bool("policy.write", false, "Write the merged policy back to policy.file instead of printing it")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(p)
+}
+
+func newPolicyCmdApp() *policyCmdApp {
+	return &policyCmdApp{
+		EmptyApplication: application.NewEmptyApplication("policyCmdApp"),
+	}
+}
+
+func (p *policyCmdApp) Configuration() configuration.Configuration {
+	return &p.config
+}
+
+func (p *policyCmdApp) Run(ctx context.Context) {
+	cobra.CheckErr(p.run(ctx))
+}
+
+func (p *policyCmdApp) run(ctx context.Context) error {
+	if p.config.Server == "" {
+		return errors.Errorf("policy.server is required")
+	}
+	if p.config.Write && p.config.File == "" {
+		return errors.Errorf("policy.write requires policy.file")
+	}
+
+	m, err := p.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	derpMap := m.Policy(p.config.OmitDefaultRegions)
+
+	if p.config.File == "" {
+		data, err := derperer.PolicyFragment(derpMap)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	policy, err := os.ReadFile(p.config.File)
+	if err != nil {
+		return err
+	}
+	data, err := derperer.MergePolicy(policy, derpMap)
+	if err != nil {
+		return errors.Errorf("%s: %w", p.config.File, err)
+	}
+	if !p.config.Write {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	info, err := os.Stat(p.config.File)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p.config.File, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	p.Logger.Info("merged derp map into policy", zap.String("file", p.config.File), zap.Int("regions", len(derpMap.Regions)))
+	return nil
+}
+
+func (p *policyCmdApp) fetch(ctx context.Context) (*derperer.DERPMap, error) {
+	url := strings.TrimSuffix(p.config.Server, "/") + "/derp.json"
+	if p.config.Query != "" {
+		url += "?" + strings.TrimPrefix(p.config.Query, "?")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s: %s", url, resp.Status)
+	}
+	var m derperer.DERPMap
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, errors.Errorf("decode derp map: %w", err)
+	}
+	return &m, nil
+}
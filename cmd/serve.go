@@ -6,6 +6,8 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/yoshino-s/derperer/internal/derperer"
 	"github.com/yoshino-s/derperer/internal/handler/http"
+	"github.com/yoshino-s/derperer/internal/output"
+	"github.com/yoshino-s/derperer/internal/source"
 	"github.com/yoshino-s/derperer/pkg/speedtest"
 	"github.com/yoshino-s/go-app/fofa"
 )
@@ -14,21 +16,99 @@ func init() {
 	httpApp.Configuration().Register(serveCmd.Flags())
 	derpererService.Configuration().Register(serveCmd.Flags())
 	fofaApp.Configuration().Register(serveCmd.Flags())
+	fofaSource.Configuration().Register(serveCmd.Flags())
+	shodanSource.Configuration().Register(serveCmd.Flags())
+	censysSource.Configuration().Register(serveCmd.Flags())
+	zoomeyeSource.Configuration().Register(serveCmd.Flags())
+	hunterSource.Configuration().Register(serveCmd.Flags())
+	staticSource.Configuration().Register(serveCmd.Flags())
+	derpMapSource.Configuration().Register(serveCmd.Flags())
+	scanSource.Configuration().Register(serveCmd.Flags())
+	ctSource.Configuration().Register(serveCmd.Flags())
+	federationSource.Configuration().Register(serveCmd.Flags())
+	fileSink.Configuration().Register(serveCmd.Flags())
+	webhookSink.Configuration().Register(serveCmd.Flags())
+	headscaleSink.Configuration().Register(serveCmd.Flags())
 
 	rootCmd.AddCommand(serveCmd)
 }
 
 var (
-	httpApp         = http.New()
-	derpererService = derperer.New()
-	fofaApp         = fofa.New()
+	httpApp          = http.New()
+	derpererService  = derperer.New()
+	fofaApp          = fofa.New()
+	fofaSource       = source.NewFofa()
+	shodanSource     = source.NewShodan()
+	censysSource     = source.NewCensys()
+	zoomeyeSource    = source.NewZoomEye()
+	hunterSource     = source.NewHunter()
+	staticSource     = source.NewStatic()
+	derpMapSource    = source.NewDERPMap()
+	scanSource       = source.NewScan()
+	ctSource         = source.NewCT()
+	federationSource = source.NewFederation()
+	fileSink         = output.NewFile()
+	webhookSink      = output.NewWebhook()
+	headscaleSink    = output.NewHeadscale()
 
 	serveCmd = &cobra.Command{
 		Use:   "serve",
 		Short: `Serve runs the HTTP server.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			app.Append(speedtest.New())
-			app.Append(fofaApp)
+			if fofaSource.Enabled() {
+				app.Append(fofaApp)
+				app.Append(fofaSource)
+				derpererService.AddSource(fofaSource)
+			}
+			if shodanSource.Enabled() {
+				app.Append(shodanSource)
+				derpererService.AddSource(shodanSource)
+			}
+			if censysSource.Enabled() {
+				app.Append(censysSource)
+				derpererService.AddSource(censysSource)
+			}
+			if zoomeyeSource.Enabled() {
+				app.Append(zoomeyeSource)
+				derpererService.AddSource(zoomeyeSource)
+			}
+			if hunterSource.Enabled() {
+				app.Append(hunterSource)
+				derpererService.AddSource(hunterSource)
+			}
+			if staticSource.Enabled() {
+				app.Append(staticSource)
+				derpererService.AddSource(staticSource)
+			}
+			if derpMapSource.Enabled() {
+				app.Append(derpMapSource)
+				derpererService.AddSource(derpMapSource)
+			}
+			if scanSource.Enabled() {
+				app.Append(scanSource)
+				derpererService.AddSource(scanSource)
+			}
+			if ctSource.Enabled() {
+				app.Append(ctSource)
+				derpererService.AddSource(ctSource)
+			}
+			if federationSource.Enabled() {
+				app.Append(federationSource)
+				derpererService.AddSource(federationSource)
+			}
+			if fileSink.Enabled() {
+				app.Append(fileSink)
+				derpererService.AddSink(fileSink)
+			}
+			if webhookSink.Enabled() {
+				app.Append(webhookSink)
+				derpererService.AddSink(webhookSink)
+			}
+			if headscaleSink.Enabled() {
+				app.Append(headscaleSink)
+				derpererService.AddSink(headscaleSink)
+			}
 			app.Append(derpererService)
 
 			app.Append(httpApp)
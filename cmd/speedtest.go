@@ -2,9 +2,6 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
-	"io"
-	"net/http"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -16,13 +13,12 @@ import (
 	"github.com/yoshino-s/go-framework/configuration"
 	"github.com/yoshino-s/go-framework/utils"
 	"go.uber.org/zap"
-	"tailscale.com/tailcfg"
 )
 
 var (
 	speedtestApp = newSpeedTestCmdApp()
 	speedtestCmd = &cobra.Command{
-		Use: "speedtest",
+		Use:   "speedtest",
 		Short: "Run a speed test",
 		Run: func(cmd *cobra.Command, args []string) {
 			app.Append(speedtest.New())
@@ -49,6 +45,9 @@ type speedTestCmdConfig struct {
 	DerpMapUrl   string        `mapstructure:"derp_map_url"`
 	DerpRegionId int           `mapstructure:"derp_region_id"`
 	Duration     time.Duration `mapstructure:"duration"`
+
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`
+	HandshakeTimeout time.Duration `mapstructure:"handshake_timeout"`
 }
 
 func (s *speedTestCmdConfig) Read() {
@@ -59,6 +58,8 @@ func (s *speedTestCmdConfig) Register(set *pflag.FlagSet) {
 	set.String("derp_map_url", "https://controlplane.tailscale.com/derpmap/default", "derp map url")
 	set.Int("derp_region_id", 0, "derp region id")
 	set.Duration("duration", time.Second*30, "duration")
+	set.Duration("connect_timeout", speedtest.DefaultConnectTimeout, "timeout for resolving, connecting and the TLS handshake")
+	set.Duration("handshake_timeout", speedtest.DefaultHandshakeTimeout, "timeout for the DERP upgrade and handshake")
 	utils.MustNoError(viper.BindPFlags(set))
 	configuration.Register(s)
 }
@@ -74,37 +75,37 @@ func (s *speedTestCmdApp) Configuration() configuration.Configuration {
 }
 
 func (s *speedTestCmdApp) Run(ctx context.Context) {
+	cobra.CheckErr(s.run(ctx))
+}
+
+func (s *speedTestCmdApp) run(ctx context.Context) error {
 	logger := s.Logger
 
-	req, err := http.NewRequestWithContext(ctx, "GET", s.config.DerpMapUrl, nil)
+	dmap, err := speedtest.FetchDERPMap(ctx, s.config.DerpMapUrl)
 	if err != nil {
-		panic(errors.Errorf("create derp map request: %w", err))
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		panic(errors.Errorf("fetch derp map failed: %w", err))
-	}
-	defer res.Body.Close()
-	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
-	if err != nil {
-		panic(errors.Errorf("fetch derp map failed: %w", err))
-	}
-	if res.StatusCode != 200 {
-		panic(errors.Errorf("fetch derp map: %v: %s", res.Status, b))
-	}
-	var dmap tailcfg.DERPMap
-	if err = json.Unmarshal(b, &dmap); err != nil {
-		panic(errors.Errorf("fetch DERP map: %w", err))
+		return err
 	}
 	region := dmap.Regions[s.config.DerpRegionId]
 	if region == nil {
-		panic(errors.Errorf("derp region %d not found, vailable regions: %v", s.config.DerpRegionId, dmap.RegionIDs()))
+		return errors.Errorf("derp region %d not found, vailable regions: %v", s.config.DerpRegionId, dmap.RegionIDs())
 	}
 	logger.Info("derp region", zap.Any("region", region))
 
-	if res, err := s.SpeedtestService.CheckDerp(region, s.config.Duration); err != nil {
-		cobra.CheckErr(errors.Errorf("check derp: %w", err))
-	} else {
-		logger.Sugar().Infof("bandwidth: %s, totalBytes: %s, latency: %s", res.Bps.String(), res.TotalBytesSent.String(), res.Latency.String())
+	res, err := s.SpeedtestService.CheckDerp(ctx, region, speedtest.CheckOptions{
+		ConnectTimeout:   s.config.ConnectTimeout,
+		HandshakeTimeout: s.config.HandshakeTimeout,
+		Duration:         s.config.Duration,
+	})
+	if res != nil {
+		logger.Sugar().Infof("sender timing: %s", res.Timing.Sender)
+		logger.Sugar().Infof("receiver timing: %s", res.Timing.Receiver)
+	}
+	if err != nil {
+		return errors.Errorf("check derp (%s): %w", speedtest.ClassOf(err), err)
 	}
+	logger.Sugar().Infof("bandwidth: %s, totalBytes: %s, latency: %s", res.Bps.String(), res.TotalBytesSent.String(), res.Latency.String())
+	logger.Sugar().Infof("latency min/p50/p90/p99/max: %s/%s/%s/%s/%s, jitter: %s",
+		res.LatencyStats.Min, res.LatencyStats.P50, res.LatencyStats.P90, res.LatencyStats.P99, res.LatencyStats.Max, res.LatencyStats.Jitter)
+	logger.Sugar().Infof("probes received: %d, lost: %d, loss: %.2f%%", res.PacketsReceived, res.PacketsLost, res.PacketLoss*100)
+	return nil
 }
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/sourcegraph/conc/pool"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+var _ configuration.Configuration = (*config)(nil)
+
+type config struct {
+	Server  string `mapstructure:"server"`
+	Token   string `mapstructure:"token"`
+	Vantage string `mapstructure:"vantage"`
+
+	Interval         time.Duration `mapstructure:"interval"`
+	CheckDuration    time.Duration `mapstructure:"check_duration"`
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`
+	HandshakeTimeout time.Duration `mapstructure:"handshake_timeout"`
+	CheckConcurrency int           `mapstructure:"check_concurrency"`
+}
+
+func (c *config) Register(set *pflag.FlagSet) {
+	set.String("agent.server", "", "URL of the central derperer")
+	set.String("agent.token", "", "Agent token of the central derperer")
+	set.String("agent.vantage", "", "Name of the vantage point the results are reported for, empty for the hostname")
+	set.Duration("agent.interval", time.Minute, "The interval at which to check the endpoints")
+	set.Duration("agent.check_duration", time.Second*10, "The duration for which to check nodes")
+	set.Duration("agent.connect_timeout", speedtest.DefaultConnectTimeout, "The timeout for resolving, connecting to and the TLS handshake with nodes")
+	set.Duration("agent.handshake_timeout", speedtest.DefaultHandshakeTimeout, "The timeout for the DERP upgrade and handshake with nodes")
+	set.Int("agent.check_concurrency", 4, "The number of concurrent tests to run")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *config) Read() {
+	utils.MustDecodeFromMapstructure(viper.AllSettings()["agent"], c)
+}
+
+// Agent checks the endpoints of a central derperer from its own vantage point
+// and reports the results back.
+type Agent struct {
+	*application.EmptyApplication
+	config config
+
+	SpeedtestService *speedtest.SpeedTestService `inject:""`
+}
+
+func New() *Agent {
+	return &Agent{
+		EmptyApplication: application.NewEmptyApplication("Agent"),
+	}
+}
+
+func (a *Agent) Configuration() configuration.Configuration {
+	return &a.config
+}
+
+func (a *Agent) Setup(context.Context) {
+	if a.config.Server == "" || a.config.Token == "" {
+		a.Logger.Fatal("agent.server and agent.token are required")
+	}
+	if a.config.Vantage == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			a.Logger.Fatal("agent.vantage is required", zap.Error(err))
+		}
+		a.config.Vantage = hostname
+	}
+	a.config.Server = strings.TrimSuffix(a.config.Server, "/")
+}
+
+func (a *Agent) Run(ctx context.Context) {
+	for {
+		if err := a.cycle(ctx); err != nil && ctx.Err() == nil {
+			a.Logger.Error("failed to check endpoints", zap.Error(err))
+		}
+		select {
+		case <-time.After(a.config.Interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Agent) cycle(ctx context.Context) error {
+	endpoints, err := a.endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	a.Logger.Debug("checking endpoints", zap.Int("count", len(endpoints)))
+
+	opts := speedtest.CheckOptions{
+		ConnectTimeout:   a.config.ConnectTimeout,
+		HandshakeTimeout: a.config.HandshakeTimeout,
+		Duration:         a.config.CheckDuration,
+	}
+	p := pool.New().WithMaxGoroutines(a.config.CheckConcurrency)
+	var mu sync.Mutex
+	report := &derperer.AgentReport{Vantage: a.config.Vantage}
+	for _, endpoint := range endpoints {
+		p.Go(func() {
+			if ctx.Err() != nil {
+				return
+			}
+			res, err := a.SpeedtestService.CheckDerp(ctx, endpoint.Convert().ToOriginal(), opts)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				a.Logger.Debug("failed to check derp", zap.String("host", endpoint.Host), zap.Int("port", endpoint.Port), zap.Error(err))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Results = append(report.Results, derperer.AgentResult{
+				Host:   endpoint.Host,
+				Port:   endpoint.Port,
+				Result: derperer.NewCheckResult(res, err),
+			})
+		})
+	}
+	p.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.report(ctx, report)
+}
+
+func (a *Agent) do(ctx context.Context, method string, path string, body any) (*http.Response, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.config.Server+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (a *Agent) endpoints(ctx context.Context) (derperer.DerpEndpoints, error) {
+	resp, err := a.do(ctx, http.MethodGet, "/agent/endpoints", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res derperer.FederationExport
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("decode endpoints: %w", err)
+	}
+	return res.Endpoints, nil
+}
+
+func (a *Agent) report(ctx context.Context, report *derperer.AgentReport) error {
+	resp, err := a.do(ctx, http.MethodPost, "/agent/results", report)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	a.Logger.Info("reported results", zap.String("vantage", report.Vantage), zap.Int("count", len(report.Results)))
+	return nil
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+)
+
+// fakeServer is the API of a central derperer for agents.
+type fakeServer struct {
+	endpoints derperer.DerpEndpoints
+	reports   chan *derperer.AgentReport
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/agent/endpoints":
+		json.NewEncoder(w).Encode(derperer.FederationExport{Endpoints: s.endpoints})
+	case r.Method == http.MethodPost && r.URL.Path == "/agent/results":
+		var report derperer.AgentReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.reports <- &report
+		json.NewEncoder(w).Encode(map[string]int{"recorded": len(report.Results)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// closedPort returns a loopback port which refuses connections.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func newTestAgent(server string, token string) *Agent {
+	a := New()
+	a.SpeedtestService = speedtest.New()
+	a.config = config{
+		Server:           server + "/",
+		Token:            token,
+		Vantage:          "tokyo",
+		Interval:         time.Minute,
+		CheckDuration:    time.Second,
+		ConnectTimeout:   time.Second,
+		HandshakeTimeout: time.Second,
+		CheckConcurrency: 2,
+	}
+	a.Setup(context.Background())
+	return a
+}
+
+func TestAgentChecksAndReports(t *testing.T) {
+	s := &fakeServer{reports: make(chan *derperer.AgentReport, 1)}
+	for range 2 {
+		s.endpoints = append(s.endpoints, &derperer.DerpEndpoint{Host: "127.0.0.1", Port: closedPort(t), IPv4: "127.0.0.1"})
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	if err := newTestAgent(ts.URL, "secret").cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	report := <-s.reports
+	if report.Vantage != "tokyo" {
+		t.Errorf("reported for vantage %q, want tokyo", report.Vantage)
+	}
+	if len(report.Results) != len(s.endpoints) {
+		t.Fatalf("reported %d results, want %d", len(report.Results), len(s.endpoints))
+	}
+	ports := map[int]bool{}
+	for _, result := range report.Results {
+		ports[result.Port] = true
+		if result.Host != "127.0.0.1" || result.Result == nil {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		if result.Result.Status != derperer.DerpStatusError || result.Result.ErrorClass != speedtest.ErrorClassDial {
+			t.Errorf("closed port reported as %s (%s), want %s (%s)", result.Result.Status, result.Result.ErrorClass, derperer.DerpStatusError, speedtest.ErrorClassDial)
+		}
+	}
+	for _, endpoint := range s.endpoints {
+		if !ports[endpoint.Port] {
+			t.Errorf("no result for port %d", endpoint.Port)
+		}
+	}
+}
+
+func TestAgentSendsToken(t *testing.T) {
+	s := &fakeServer{reports: make(chan *derperer.AgentReport, 1)}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	if err := newTestAgent(ts.URL, "wrong").cycle(context.Background()); err == nil {
+		t.Error("no error for a rejected token")
+	}
+	if err := newTestAgent(ts.URL, "secret").cycle(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if report := <-s.reports; len(report.Results) != 0 {
+		t.Errorf("reported %d results without endpoints", len(report.Results))
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/pkg/speedtest"
 	"github.com/yoshino-s/go-framework/configuration"
 	"github.com/yoshino-s/go-framework/utils"
 )
@@ -17,22 +18,89 @@ type config struct {
 
 	RecheckInterval  time.Duration `mapstructure:"recheck_interval"`
 	CheckDuration    time.Duration `mapstructure:"check_duration"`
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`
+	HandshakeTimeout time.Duration `mapstructure:"handshake_timeout"`
 	CheckConcurrency int           `mapstructure:"check_concurrency"`
 
+	EvictAfter time.Duration `mapstructure:"evict_after"`
+	// ResultMaxAge drops the results of agents and federation peers which
+	// stopped reporting.
+	ResultMaxAge time.Duration `mapstructure:"result_max_age"`
+
+	ReadyMinAvailable int `mapstructure:"ready_min_available"`
+
 	CN bool `mapstructure:"cn"`
+
+	Storage string `mapstructure:"storage"`
+
+	RegionIDMin int `mapstructure:"region_id_min"`
+	RegionIDMax int `mapstructure:"region_id_max"`
+
+	GroupBy   string `mapstructure:"group_by"`
+	GroupRule string `mapstructure:"group_rule"`
+
+	Score scoreConfig `mapstructure:"score"`
+	GeoIP geoIPConfig `mapstructure:"geoip"`
+
+	FederationToken string `mapstructure:"federation_token"`
+	AgentToken      string `mapstructure:"agent_token"`
 }
 
 func (c *config) Register(set *pflag.FlagSet) {
-	set.Duration("derperer.refetch_interval", time.Minute*10, "The interval at which to fetch data")
-	set.Int("derperer.fetch_limit", 100, "Limit of fofa result to fetch")
+	set.Duration("derperer.refetch_interval", time.Minute*10, "Default refetch interval of each discovery source")
+	set.Int("derperer.fetch_limit", 100, "Default result limit of each discovery source")
 	set.Duration("derperer.recheck_interval", time.Second*10, "The interval at which to recheck abandoned nodes")
 	set.Duration("derperer.check_duration", time.Second*10, "The duration for which to check nodes")
+	set.Duration("derperer.connect_timeout", speedtest.DefaultConnectTimeout, "The timeout for resolving, connecting to and the TLS handshake with nodes")
+	set.Duration("derperer.handshake_timeout", speedtest.DefaultHandshakeTimeout, "The timeout for the DERP upgrade and handshake with nodes")
 	set.Int("derperer.check_concurrency", 10, "The number of concurrent tests to run")
+	set.Duration("derperer.evict_after", 0, "Remove endpoints which were not available for this long, 0 to keep them forever")
+	set.Duration("derperer.result_max_age", time.Minute*30, "Drop the check results of agents and federation peers older than this, 0 to keep them forever")
+	set.Int("derperer.ready_min_available", 1, "The number of available endpoints required to report ready")
 	set.Bool("derperer.cn", false, "Only fetch nodes in China")
+	set.Int("derperer.region_id_min", 900, "The lowest region ID assigned to endpoints")
+	set.Int("derperer.region_id_max", 65535, "The highest region ID assigned to endpoints")
+	set.String("derperer.group_by", GroupNone, "Group endpoints into regions by none, country, city, asn or rule")
+	set.String("derperer.group_rule", "", "Go template of the region of an endpoint for derperer.group_by rule, e.g. {{.Country}}-{{.ASN}}")
+	set.Float64("derperer.score.latency_weight", 1, "Weight of the latency relative to the median in region scores")
+	set.Float64("derperer.score.bandwidth_weight", 0.5, "Weight of the bandwidth relative to the median in region scores")
+	set.Float64("derperer.score.uptime_weight", 1, "Weight of the uptime ratio in region scores")
+	set.Float64("derperer.score.jitter_weight", 0.5, "Weight of the jitter relative to the median in region scores")
+	set.Float64("derperer.score.preference_weight", 1, "Weight of the operator preference in region scores")
+	set.StringSlice("derperer.score.preferences", nil, "Score factors of endpoints as host=factor, region code=factor or source=factor, below 1 to prefer them")
+	set.StringSlice("derperer.geoip.databases", nil, "MaxMind format city, country or ASN databases to geolocate clients and endpoints")
+	set.Int("derperer.geoip.nearest", 0, "The number of endpoints nearest to the client to serve, 0 to serve all unless asked for")
+	set.Float64("derperer.geoip.bias", 0.5, "Score factor of endpoints on the network, in the country and on the continent of the client")
+	set.String("derperer.federation_token", "", "Token federation peers must present to export endpoints, empty to disable the export")
+	set.String("derperer.agent_token", "", "Token agents must present to fetch endpoints and report results, empty to disable agents")
+	set.String("derperer.storage", "", "Path of the endpoint database, empty to keep endpoints in memory only")
 	utils.MustNoError(viper.BindPFlags(set))
 	configuration.Register(c)
 }
 
+type scoreConfig struct {
+	ScoreWeights `mapstructure:",squash"`
+	Preferences  []string `mapstructure:"preferences"`
+}
+
+type geoIPConfig struct {
+	Databases []string `mapstructure:"databases"`
+	Nearest   int      `mapstructure:"nearest"`
+	Bias      float64  `mapstructure:"bias"`
+}
+
 func (c *config) Read() {
 	utils.MustDecodeFromMapstructure(viper.AllSettings()["derperer"], c)
 }
+
+func (c *config) regionIDRange() IDRange {
+	return IDRange{Min: c.RegionIDMin, Max: c.RegionIDMax}
+}
+
+func (c *config) checkOptions() speedtest.CheckOptions {
+	return speedtest.CheckOptions{
+		ConnectTimeout:   c.ConnectTimeout,
+		HandshakeTimeout: c.HandshakeTimeout,
+		Duration:         c.CheckDuration,
+	}
+}
@@ -1,6 +1,8 @@
 package derperer
 
 import (
+	"cmp"
+	"maps"
 	"time"
 
 	"github.com/yoshino-s/derperer/pkg/speedtest"
@@ -18,18 +20,52 @@ const (
 type DerpEndpoint struct {
 	ID   int    `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
+	// NodeName is the node name of an imported DERP map, Name if empty.
+	NodeName string `json:"node_name,omitempty"`
+	// OriginalID is the region ID of an imported DERP map to keep.
+	OriginalID int `json:"original_id,omitempty"`
 
 	Region   string `json:"region"`
+	Country  string `json:"country,omitempty"`
+	City     string `json:"city,omitempty"`
+	ASN      string `json:"asn,omitempty"`
 	Host     string `json:"host"`
 	IPv4     string `json:"ipv4,omitempty"`
 	IPv6     string `json:"ipv6,omitempty"`
 	Port     int    `json:"port,omitempty"`
 	Insecure bool   `json:"insecure_for_tests,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Pinned   bool   `json:"pinned,omitempty"`
+	// Peer is the federation peer the endpoint was imported from.
+	Peer string `json:"peer,omitempty"`
 
 	Status    DerpStatus     `json:"status"`
 	Latency   time.Duration  `json:"latency,omitempty"`
 	Bandwidth speedtest.Unit `json:"bandwidth,omitempty"`
-	Error     string         `json:"error,omitempty"`
+
+	LatencyStats speedtest.LatencyStats `json:"latency_stats,omitzero"`
+	PacketLoss   float64                `json:"packet_loss,omitempty"`
+	Timing       speedtest.Timing       `json:"timing,omitzero"`
+
+	Error      string               `json:"error,omitempty"`
+	ErrorClass speedtest.ErrorClass `json:"error_class,omitempty"`
+	CheckedAt  time.Time            `json:"checked_at,omitzero"`
+
+	DiscoveredAt    time.Time `json:"discovered_at,omitzero"`
+	LastAvailableAt time.Time `json:"last_available_at,omitzero"`
+	// Uptime is the moving average ratio of local checks the endpoint was
+	// available in, 0 if unknown.
+	Uptime float64 `json:"uptime,omitempty"`
+
+	// Results are the check results of other vantage points, keyed by
+	// AgentVantage or PeerVantage.
+	Results map[string]*CheckResult `json:"results,omitempty"`
+}
+
+func (d *DerpEndpoint) clone() *DerpEndpoint {
+	c := *d
+	c.Results = maps.Clone(d.Results)
+	return &c
 }
 
 func (d *DerpEndpoint) Convert() *DERPRegion {
@@ -39,22 +75,39 @@ func (d *DerpEndpoint) Convert() *DERPRegion {
 			RegionCode: d.Name,
 			RegionName: d.Name,
 		},
-		Nodes: []*DERPNode{
-			{
-				DERPNode: tailcfg.DERPNode{
-					Name:             d.Name,
-					RegionID:         d.ID,
-					HostName:         d.Host,
-					IPv4:             d.IPv4,
-					IPv6:             d.IPv6,
-					DERPPort:         d.Port,
-					InsecureForTests: d.Insecure,
-				},
-				Latency:   d.Latency.String(),
-				Bandwidth: d.Bandwidth.String(),
-				Status:    d.Status,
-			},
+		Nodes: []*DERPNode{d.node(d.ID)},
+	}
+}
+
+// node converts the endpoint to a node of the region regionID.
+func (d *DerpEndpoint) node(regionID int) *DERPNode {
+	return &DERPNode{
+		DERPNode: tailcfg.DERPNode{
+			Name:             cmp.Or(d.NodeName, d.Name),
+			RegionID:         regionID,
+			HostName:         d.Host,
+			IPv4:             d.IPv4,
+			IPv6:             d.IPv6,
+			DERPPort:         d.Port,
+			InsecureForTests: d.Insecure,
 		},
+		Latency:    d.Latency.String(),
+		LatencyMin: d.LatencyStats.Min.String(),
+		LatencyP50: d.LatencyStats.P50.String(),
+		LatencyP90: d.LatencyStats.P90.String(),
+		LatencyP99: d.LatencyStats.P99.String(),
+		LatencyMax: d.LatencyStats.Max.String(),
+		Jitter:     d.LatencyStats.Jitter.String(),
+		PacketLoss: d.PacketLoss,
+		Bandwidth:  d.Bandwidth.String(),
+		Status:     d.Status,
+		Error:      d.Error,
+		ErrorClass: string(d.ErrorClass),
+
+		SenderTiming:   newDERPNodeTiming(d.Timing.Sender),
+		ReceiverTiming: newDERPNodeTiming(d.Timing.Receiver),
+
+		Results: newDERPNodeResults(d.Results),
 	}
 }
 
@@ -62,7 +115,9 @@ type DerpEndpoints []*DerpEndpoint
 
 func (d DerpEndpoints) Len() int { return len(d) }
 
-func (d DerpEndpoints) Convert() *DERPMap {
+// Convert converts the endpoints to a DERP map with a region per endpoint,
+// scored by scores.
+func (d DerpEndpoints) Convert(scores Scores) *DERPMap {
 	if d == nil {
 		return &DERPMap{
 			DERPMap: tailcfg.DERPMap{
@@ -79,8 +134,13 @@ func (d DerpEndpoints) Convert() *DERPMap {
 		Regions: make(map[int]*DERPRegion),
 	}
 	for _, endpoint := range d {
-		m.Regions[endpoint.ID] = endpoint.Convert()
-		m.HomeParams.RegionScore[endpoint.ID] = (endpoint.Bandwidth.Value / (1000 * 1024 * 1024))
+		region := endpoint.Convert()
+		m.Regions[endpoint.ID] = region
+		if score, ok := scores[endpoint]; ok {
+			region.Score = score
+			region.Nodes[0].Score = score
+			m.HomeParams.RegionScore[endpoint.ID] = score
+		}
 	}
 	return m
 }
@@ -89,9 +149,34 @@ type DerpQueryParams struct {
 	Status         DerpStatus    `query:"status" json:"status" enums:"alive,error,all"`
 	LatencyLimit   time.Duration `query:"latency-limit" json:"latency_limit"`
 	BandwidthLimit string        `query:"bandwidth-limit" json:"bandwidth_limit"`
+	ErrorClass     string        `query:"error-class" json:"error_class"`
+	Vantage        string        `query:"vantage" json:"vantage"`
+	Group          string        `query:"group" json:"group" enums:"none,country,city,asn,rule"`
+	Nearest        int           `query:"nearest" json:"nearest"`
+	Client         string        `query:"client" json:"client"`
+}
+
+// Vantage returns copies of the endpoints with the check results of the
+// vantage point instead of the local ones, endpoints it did not check are
+// unknown. A bare name is an agent, see vantageKey. Only the latest result of
+// other vantage points is kept, so their uptime is unknown.
+func (d DerpEndpoints) Vantage(vantage string) DerpEndpoints {
+	key := vantageKey(vantage)
+	res := make(DerpEndpoints, 0, len(d))
+	for _, endpoint := range d {
+		endpoint = endpoint.clone()
+		endpoint.apply(endpoint.Results[key])
+		endpoint.Timing = speedtest.Timing{}
+		endpoint.Uptime = 0
+		res = append(res, endpoint)
+	}
+	return res
 }
 
 func (d DerpEndpoints) Query(params *DerpQueryParams) DerpEndpoints {
+	if params.Vantage != "" {
+		d = d.Vantage(params.Vantage)
+	}
 	var res DerpEndpoints
 	for _, endpoint := range d {
 		if params.Status != "" && endpoint.Status != params.Status {
@@ -100,6 +185,9 @@ func (d DerpEndpoints) Query(params *DerpQueryParams) DerpEndpoints {
 		if params.LatencyLimit != 0 && endpoint.Latency > params.LatencyLimit {
 			continue
 		}
+		if params.ErrorClass != "" && string(endpoint.ErrorClass) != params.ErrorClass {
+			continue
+		}
 		if params.BandwidthLimit != "" {
 			bandwidthLimit, err := speedtest.ParseUnit(params.BandwidthLimit, "bps")
 			if err != nil {
@@ -113,12 +201,3 @@ func (d DerpEndpoints) Query(params *DerpQueryParams) DerpEndpoints {
 	}
 	return res
 }
-
-func (d DerpEndpoints) Exist(host string, port int) (*DerpEndpoint, bool) {
-	for _, endpoint := range d {
-		if endpoint.Host == host && endpoint.Port == port {
-			return endpoint, true
-		}
-	}
-	return nil, false
-}
@@ -1,6 +1,9 @@
 package derperer
 
 import (
+	"time"
+
+	"github.com/yoshino-s/derperer/pkg/speedtest"
 	"tailscale.com/tailcfg"
 )
 
@@ -12,13 +15,80 @@ type DERPMap struct {
 type DERPRegion struct {
 	tailcfg.DERPRegion
 	Nodes []*DERPNode
+	// Score is the region score of HomeParams, 0 if unscored.
+	Score float64 `json:"score,omitempty"`
 }
 
 type DERPNode struct {
 	tailcfg.DERPNode
-	Latency   string     `json:"latency,omitempty"`
-	Bandwidth string     `json:"bandwidth,omitempty"`
-	Status    DerpStatus `json:"status,omitempty"`
+	Latency    string     `json:"latency,omitempty"`
+	LatencyMin string     `json:"latency_min,omitempty"`
+	LatencyP50 string     `json:"latency_p50,omitempty"`
+	LatencyP90 string     `json:"latency_p90,omitempty"`
+	LatencyP99 string     `json:"latency_p99,omitempty"`
+	LatencyMax string     `json:"latency_max,omitempty"`
+	Jitter     string     `json:"jitter,omitempty"`
+	PacketLoss float64    `json:"packet_loss,omitempty"`
+	Bandwidth  string     `json:"bandwidth,omitempty"`
+	Status     DerpStatus `json:"status,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ErrorClass string     `json:"error_class,omitempty"`
+	Score      float64    `json:"score,omitempty"`
+
+	SenderTiming   *DERPNodeTiming `json:"sender_timing,omitempty"`
+	ReceiverTiming *DERPNodeTiming `json:"receiver_timing,omitempty"`
+
+	Results map[string]*DERPNodeResult `json:"results,omitempty"`
+}
+
+// DERPNodeResult is the check result of the node from another vantage point.
+type DERPNodeResult struct {
+	Status     DerpStatus `json:"status"`
+	Latency    string     `json:"latency,omitempty"`
+	Bandwidth  string     `json:"bandwidth,omitempty"`
+	PacketLoss float64    `json:"packet_loss,omitempty"`
+	ErrorClass string     `json:"error_class,omitempty"`
+	CheckedAt  time.Time  `json:"checked_at,omitzero"`
+}
+
+func newDERPNodeResults(results map[string]*CheckResult) map[string]*DERPNodeResult {
+	if len(results) == 0 {
+		return nil
+	}
+	m := make(map[string]*DERPNodeResult, len(results))
+	for vantage, result := range results {
+		m[vantage] = &DERPNodeResult{
+			Status:     result.Status,
+			Latency:    result.Latency.String(),
+			Bandwidth:  result.Bandwidth.String(),
+			PacketLoss: result.PacketLoss,
+			ErrorClass: string(result.ErrorClass),
+			CheckedAt:  result.CheckedAt,
+		}
+	}
+	return m
+}
+
+// DERPNodeTiming is the connection timing of one client of the last check.
+type DERPNodeTiming struct {
+	DNS        string `json:"dns"`
+	TCP        string `json:"tcp"`
+	TLS        string `json:"tls"`
+	Upgrade    string `json:"upgrade"`
+	ServerInfo string `json:"server_info"`
+}
+
+func newDERPNodeTiming(t speedtest.ConnTiming) *DERPNodeTiming {
+	if t == (speedtest.ConnTiming{}) {
+		return nil
+	}
+	return &DERPNodeTiming{
+		DNS:        t.DNS.String(),
+		TCP:        t.TCP.String(),
+		TLS:        t.TLS.String(),
+		Upgrade:    t.Upgrade.String(),
+		ServerInfo: t.ServerInfo.String(),
+	}
 }
 
 func (n *DERPNode) ToOriginal() *tailcfg.DERPNode {
@@ -0,0 +1,210 @@
+package derperer
+
+import (
+	"crypto/subtle"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+)
+
+// CheckResult is the result of checking an endpoint from a vantage point,
+// e.g. a federation peer. Results are never modified once recorded.
+type CheckResult struct {
+	Status    DerpStatus     `json:"status"`
+	Latency   time.Duration  `json:"latency,omitempty"`
+	Bandwidth speedtest.Unit `json:"bandwidth,omitempty"`
+
+	LatencyStats speedtest.LatencyStats `json:"latency_stats,omitzero"`
+	PacketLoss   float64                `json:"packet_loss,omitempty"`
+
+	Error      string               `json:"error,omitempty"`
+	ErrorClass speedtest.ErrorClass `json:"error_class,omitempty"`
+	CheckedAt  time.Time            `json:"checked_at,omitzero"`
+}
+
+// NewCheckResult converts the result of a check.
+func NewCheckResult(res *speedtest.SpeedTestResult, err error) *CheckResult {
+	if err != nil {
+		return &CheckResult{
+			Status:     DerpStatusError,
+			Bandwidth:  speedtest.Unit{Value: 0, Uint: "bps"},
+			Error:      err.Error(),
+			ErrorClass: speedtest.ClassOf(err),
+			CheckedAt:  time.Now(),
+		}
+	}
+	return &CheckResult{
+		Status:       DerpStatusAvailable,
+		Latency:      res.Latency,
+		Bandwidth:    res.Bps,
+		LatencyStats: res.LatencyStats,
+		PacketLoss:   res.PacketLoss,
+		CheckedAt:    time.Now(),
+	}
+}
+
+// apply replaces the local check result of the endpoint, a nil result
+// resets it to unknown.
+func (d *DerpEndpoint) apply(result *CheckResult) {
+	if result == nil {
+		result = &CheckResult{Status: DerpStatusUnknown}
+	}
+	d.Status = result.Status
+	d.Latency = result.Latency
+	d.Bandwidth = result.Bandwidth
+	d.LatencyStats = result.LatencyStats
+	d.PacketLoss = result.PacketLoss
+	d.Error = result.Error
+	d.ErrorClass = result.ErrorClass
+	d.CheckedAt = result.CheckedAt
+}
+
+// Result returns the result of the local check of the endpoint, or nil if it
+// was not checked yet.
+func (d *DerpEndpoint) Result() *CheckResult {
+	if d.Status == DerpStatusUnknown {
+		return nil
+	}
+	return &CheckResult{
+		Status:       d.Status,
+		Latency:      d.Latency,
+		Bandwidth:    d.Bandwidth,
+		LatencyStats: d.LatencyStats,
+		PacketLoss:   d.PacketLoss,
+		Error:        d.Error,
+		ErrorClass:   d.ErrorClass,
+		CheckedAt:    d.CheckedAt,
+	}
+}
+
+// FederationExport is the endpoint list with local check results served to
+// federation peers and agents.
+type FederationExport struct {
+	Endpoints DerpEndpoints `json:"endpoints"`
+}
+
+// Export returns the endpoints for federation peers. Results of other
+// vantage points are left out, every peer only shares what it measured.
+func (d *DerpererService) Export() *FederationExport {
+	snapshot := d.Registry.Snapshot()
+	endpoints := make(DerpEndpoints, 0, len(snapshot))
+	for _, endpoint := range snapshot {
+		endpoint = endpoint.clone()
+		endpoint.Results = nil
+		endpoints = append(endpoints, endpoint)
+	}
+	return &FederationExport{Endpoints: endpoints}
+}
+
+// AgentReport holds the check results of an agent at the vantage point
+// Vantage.
+type AgentReport struct {
+	Vantage string        `json:"vantage"`
+	Results []AgentResult `json:"results"`
+}
+
+type AgentResult struct {
+	Host   string       `json:"host"`
+	Port   int          `json:"port"`
+	Result *CheckResult `json:"result"`
+}
+
+// Report records the results of an agent for known endpoints and returns the
+// number of recorded results.
+func (d *DerpererService) Report(report *AgentReport) int {
+	recorded := 0
+	for _, result := range report.Results {
+		endpoint, ok := d.Registry.Get(result.Host, result.Port)
+		if !ok || result.Result == nil {
+			continue
+		}
+		d.recordResult(endpoint, AgentVantage(report.Vantage), result.Result)
+		recorded++
+	}
+	return recorded
+}
+
+// FederationEnabled reports whether the endpoints are exported to peers.
+func (d *DerpererService) FederationEnabled() bool {
+	return d.config.FederationToken != ""
+}
+
+// AuthorizeFederation reports whether the Authorization header carries the
+// federation token.
+func (d *DerpererService) AuthorizeFederation(header string) bool {
+	return authorize(header, d.config.FederationToken)
+}
+
+// AgentsEnabled reports whether agents may fetch endpoints and report results.
+func (d *DerpererService) AgentsEnabled() bool {
+	return d.config.AgentToken != ""
+}
+
+// AuthorizeAgent reports whether the Authorization header carries the agent
+// token.
+func (d *DerpererService) AuthorizeAgent(header string) bool {
+	return authorize(header, d.config.AgentToken)
+}
+
+func authorize(header string, expected string) bool {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// AgentVantage and PeerVantage return the key of the results of an agent or
+// a federation peer. The kinds are kept apart so that an agent can't
+// overwrite the results of a peer with the same name.
+func AgentVantage(name string) string {
+	return "agent:" + name
+}
+
+func PeerVantage(name string) string {
+	return "peer:" + name
+}
+
+// vantageKey returns the key of the results of vantage, which is an agent
+// unless it is prefixed with the kind.
+func vantageKey(vantage string) string {
+	if strings.HasPrefix(vantage, "agent:") || strings.HasPrefix(vantage, "peer:") {
+		return vantage
+	}
+	return AgentVantage(vantage)
+}
+
+// recordResult stores the result of endpoint seen from vantage.
+func (d *DerpererService) recordResult(endpoint *DerpEndpoint, vantage string, result *CheckResult) {
+	d.Registry.Update(endpoint.Host, endpoint.Port, func(endpoint *DerpEndpoint) {
+		if endpoint.Results == nil {
+			endpoint.Results = map[string]*CheckResult{}
+		}
+		endpoint.Results[vantage] = result
+	})
+}
+
+// expireResults drops the results of other vantage points older than
+// ResultMaxAge, so that the map of a vantage point which stopped reporting
+// turns unknown instead of serving stale results.
+func (d *DerpererService) expireResults() {
+	if d.config.ResultMaxAge == 0 {
+		return
+	}
+	for _, endpoint := range d.Registry.Snapshot() {
+		stale := false
+		for _, result := range endpoint.Results {
+			if time.Since(result.CheckedAt) > d.config.ResultMaxAge {
+				stale = true
+				break
+			}
+		}
+		if !stale {
+			continue
+		}
+		d.Registry.Update(endpoint.Host, endpoint.Port, func(endpoint *DerpEndpoint) {
+			maps.DeleteFunc(endpoint.Results, func(_ string, result *CheckResult) bool {
+				return time.Since(result.CheckedAt) > d.config.ResultMaxAge
+			})
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package derperer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAgentCannotOverwritePeerResults(t *testing.T) {
+	d := newTestService()
+	peer := &fakeSource{name: "federation", candidates: []*Candidate{{
+		Host:   "192.0.2.1",
+		Port:   443,
+		Peer:   "tokyo",
+		Result: &CheckResult{Status: DerpStatusAvailable, CheckedAt: time.Now()},
+	}}}
+	d.fetch(context.Background(), peer, SourceOptions{})
+
+	recorded := d.Report(&AgentReport{Vantage: "tokyo", Results: []AgentResult{{
+		Host:   "192.0.2.1",
+		Port:   443,
+		Result: &CheckResult{Status: DerpStatusError, CheckedAt: time.Now()},
+	}}})
+	if recorded != 1 {
+		t.Fatalf("recorded %d results, want 1", recorded)
+	}
+
+	endpoints := d.Registry.Snapshot()
+	if s := endpoints.Vantage(PeerVantage("tokyo"))[0].Status; s != DerpStatusAvailable {
+		t.Errorf("peer tokyo sees %s, want %s", s, DerpStatusAvailable)
+	}
+	if s := endpoints.Vantage(AgentVantage("tokyo"))[0].Status; s != DerpStatusError {
+		t.Errorf("agent tokyo sees %s, want %s", s, DerpStatusError)
+	}
+	// a bare name is the agent
+	if s := endpoints.Vantage("tokyo")[0].Status; s != DerpStatusError {
+		t.Errorf("tokyo sees %s, want %s", s, DerpStatusError)
+	}
+}
+
+func TestExpireResults(t *testing.T) {
+	d := newTestService()
+	d.config.ResultMaxAge = time.Minute
+	d.Registry.Add(&DerpEndpoint{Host: "192.0.2.1", Port: 443}, d.config.regionIDRange())
+	d.Report(&AgentReport{Vantage: "stale", Results: []AgentResult{{
+		Host:   "192.0.2.1",
+		Port:   443,
+		Result: &CheckResult{Status: DerpStatusAvailable, CheckedAt: time.Now().Add(-2 * time.Minute)},
+	}}})
+	d.Report(&AgentReport{Vantage: "fresh", Results: []AgentResult{{
+		Host:   "192.0.2.1",
+		Port:   443,
+		Result: &CheckResult{Status: DerpStatusAvailable, CheckedAt: time.Now()},
+	}}})
+
+	d.expireResults()
+	endpoints := d.Registry.Snapshot()
+	if s := endpoints.Vantage(AgentVantage("stale"))[0].Status; s != DerpStatusUnknown {
+		t.Errorf("stale vantage sees %s, want %s", s, DerpStatusUnknown)
+	}
+	if s := endpoints.Vantage(AgentVantage("fresh"))[0].Status; s != DerpStatusAvailable {
+		t.Errorf("fresh vantage sees %s, want %s", s, DerpStatusAvailable)
+	}
+}
@@ -0,0 +1,204 @@
+package derperer
+
+import (
+	"cmp"
+	"math"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Location is the geolocation of an address.
+type Location struct {
+	Continent   string
+	Country     string
+	CountryName string
+	City        string
+	ASN         string
+
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+type geoRecord struct {
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
+
+// GeoIP geolocates addresses with MaxMind format databases, e.g. a city and
+// an ASN database.
+type GeoIP struct {
+	readers []*maxminddb.Reader
+}
+
+func OpenGeoIP(paths []string) (*GeoIP, error) {
+	g := &GeoIP{}
+	for _, path := range paths {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			g.Close()
+			return nil, errors.Errorf("open %s: %w", path, err)
+		}
+		g.readers = append(g.readers, reader)
+	}
+	return g, nil
+}
+
+func (g *GeoIP) Close() error {
+	var errs []error
+	for _, reader := range g.readers {
+		errs = append(errs, reader.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup merges the records of addr of all databases, it returns nil if no
+// database knows addr.
+func (g *GeoIP) Lookup(addr netip.Addr) *Location {
+	addr = addr.Unmap()
+	var loc *Location
+	for _, reader := range g.readers {
+		result := reader.Lookup(addr)
+		if !result.Found() {
+			continue
+		}
+		var record geoRecord
+		if err := result.Decode(&record); err != nil {
+			continue
+		}
+		if loc == nil {
+			loc = &Location{}
+		}
+		loc.Continent = cmp.Or(loc.Continent, record.Continent.Code)
+		loc.Country = cmp.Or(loc.Country, record.Country.ISOCode)
+		loc.CountryName = cmp.Or(loc.CountryName, record.Country.Names["en"])
+		loc.City = cmp.Or(loc.City, record.City.Names["en"])
+		if loc.ASN == "" && record.ASN != 0 {
+			loc.ASN = "AS" + strconv.FormatUint(uint64(record.ASN), 10)
+		}
+		if !loc.HasCoordinates && record.Location.Latitude != nil && record.Location.Longitude != nil {
+			loc.Latitude, loc.Longitude = *record.Location.Latitude, *record.Location.Longitude
+			loc.HasCoordinates = true
+		}
+	}
+	return loc
+}
+
+// locate geolocates the address of the endpoint, falling back to the
+// location reported by its source.
+func (g *GeoIP) locate(endpoint *DerpEndpoint) *Location {
+	if addr, err := netip.ParseAddr(cmp.Or(endpoint.IPv4, endpoint.IPv6, endpoint.Host)); err == nil {
+		if loc := g.Lookup(addr); loc != nil {
+			loc.Country = cmp.Or(loc.Country, endpoint.Country)
+			loc.ASN = cmp.Or(loc.ASN, endpoint.ASN)
+			return loc
+		}
+	}
+	return &Location{Country: endpoint.Country, ASN: endpoint.ASN}
+}
+
+// proximity counts the matching continent, country and ASN of two
+// locations, a matching country implies a matching continent.
+func (l *Location) proximity(o *Location) int {
+	var p int
+	country := matchFold(l.Country, o.Country) || matchFold(l.Country, o.CountryName) || matchFold(l.CountryName, o.Country)
+	if country || matchFold(l.Continent, o.Continent) {
+		p++
+	}
+	if country {
+		p++
+	}
+	if matchFold(l.ASN, o.ASN) {
+		p++
+	}
+	return p
+}
+
+// distance returns the great circle distance in kilometers, or +Inf if
+// either location has no coordinates.
+func (l *Location) distance(o *Location) float64 {
+	if !l.HasCoordinates || !o.HasCoordinates {
+		return math.Inf(1)
+	}
+	const earthRadius = 6371
+	lat1, lat2 := l.Latitude*math.Pi/180, o.Latitude*math.Pi/180
+	dLat, dLon := lat2-lat1, (o.Longitude-l.Longitude)*math.Pi/180
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func matchFold(a, b string) bool {
+	return a != "" && strings.EqualFold(a, b)
+}
+
+// Nearest returns the n endpoints nearest to the client, or all of them if n
+// is 0, available ones first, ordered by proximity, distance and measured
+// latency. Their scores
+// are multiplied by bias^(proximity/3), so clients prefer relays on their
+// network, in their country and on their continent. The endpoints are
+// returned as is if the client cannot be located.
+func (g *GeoIP) Nearest(endpoints DerpEndpoints, scores Scores, client netip.Addr, n int, bias float64) (DerpEndpoints, Scores) {
+	loc := g.Lookup(client)
+	if loc == nil {
+		return endpoints, scores
+	}
+
+	type candidate struct {
+		endpoint  *DerpEndpoint
+		proximity int
+		distance  float64
+	}
+	candidates := make([]candidate, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpointLoc := g.locate(endpoint)
+		candidates = append(candidates, candidate{
+			endpoint:  endpoint,
+			proximity: loc.proximity(endpointLoc),
+			distance:  loc.distance(endpointLoc),
+		})
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		if (a.endpoint.Status == DerpStatusAvailable) != (b.endpoint.Status == DerpStatusAvailable) {
+			if a.endpoint.Status == DerpStatusAvailable {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(
+			cmp.Compare(b.proximity, a.proximity),
+			cmp.Compare(a.distance, b.distance),
+			cmp.Compare(a.endpoint.Latency, b.endpoint.Latency),
+		)
+	})
+
+	if n <= 0 || n > len(candidates) {
+		n = len(candidates)
+	}
+	nearest := make(DerpEndpoints, 0, n)
+	biased := Scores{}
+	for _, c := range candidates[:n] {
+		nearest = append(nearest, c.endpoint)
+		if score, ok := scores[c.endpoint]; ok {
+			biased[c.endpoint] = clampScore(score * math.Pow(bias, float64(c.proximity)/3))
+		}
+	}
+	return nearest, biased
+}
@@ -0,0 +1,148 @@
+package derperer
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testdata/geoip.mmdb holds city and ASN records of
+//
+//	203.0.113.0/24   AS JP AS64500 Tokyo
+//	198.51.100.0/30  AS JP AS64500 Osaka
+//	198.51.100.4/32  AS JP AS64501 Sapporo
+//	198.51.100.5/32  AS KR AS64502 Seoul
+//	198.51.100.6/32  NA US AS64503 San Francisco
+func openTestGeoIP(t *testing.T) *GeoIP {
+	t.Helper()
+	g, err := OpenGeoIP([]string{"testdata/geoip.mmdb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+var geoClient = netip.MustParseAddr("203.0.113.1")
+
+// geoTestEndpoints returns endpoints in the order Nearest ranks them for
+// geoClient, with the number of locations they share with it.
+func geoTestEndpoints() (DerpEndpoints, []int) {
+	endpoint := func(host string, status DerpStatus, latency time.Duration) *DerpEndpoint {
+		return &DerpEndpoint{Host: host, Port: 443, Status: status, Latency: latency}
+	}
+	unlocated := endpoint("192.0.2.1", DerpStatusAvailable, time.Millisecond)
+	unlocated.Country = "JP"
+	return DerpEndpoints{
+		endpoint("198.51.100.2", DerpStatusAvailable, 10*time.Millisecond), // Osaka
+		endpoint("198.51.100.1", DerpStatusAvailable, 20*time.Millisecond), // Osaka
+		endpoint("198.51.100.4", DerpStatusAvailable, 30*time.Millisecond), // Sapporo
+		unlocated, // the country of the source, no distance
+		endpoint("198.51.100.5", DerpStatusAvailable, 5*time.Millisecond), // Seoul
+		endpoint("198.51.100.6", DerpStatusAvailable, time.Millisecond),   // San Francisco
+		endpoint("198.51.100.3", DerpStatusError, 0),                      // Osaka
+	}, []int{3, 3, 2, 2, 1, 0, 3}
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	g := openTestGeoIP(t)
+	loc := g.Lookup(netip.MustParseAddr("::ffff:198.51.100.5"))
+	if loc == nil || loc.Continent != "AS" || loc.Country != "KR" || loc.CountryName != "South Korea" || loc.ASN != "AS64502" || !loc.HasCoordinates {
+		t.Errorf("located 198.51.100.5 at %+v, want Seoul", loc)
+	}
+	if loc := g.Lookup(netip.MustParseAddr("192.0.2.1")); loc != nil {
+		t.Errorf("located 192.0.2.1 at %+v, want nil", loc)
+	}
+}
+
+func TestGeoIPNearest(t *testing.T) {
+	g := openTestGeoIP(t)
+	const bias = 0.125
+	for _, tt := range []struct {
+		name string
+		n    int
+		want int
+	}{
+		{"all", 0, 7},
+		{"nearest", 3, 3},
+		{"more than there are", 10, 7},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			want, proximity := geoTestEndpoints()
+			endpoints := slices.Clone(want)
+			slices.Reverse(endpoints)
+			scores := Scores{}
+			for _, endpoint := range endpoints {
+				scores[endpoint] = 2
+			}
+
+			nearest, biased := g.Nearest(endpoints, scores, geoClient, tt.n, bias)
+			if !slices.Equal(nearest, want[:tt.want]) {
+				t.Errorf("got %v, want %v", hosts(nearest), hosts(want[:tt.want]))
+			}
+			if len(biased) != tt.want {
+				t.Errorf("%d scores, want %d", len(biased), tt.want)
+			}
+			// bias^(proximity/3)
+			factors := map[int]float64{0: 1, 1: 0.5, 2: 0.25, 3: 0.125}
+			for i, endpoint := range want[:tt.want] {
+				if score := biased[endpoint]; score != 2*factors[proximity[i]] {
+					t.Errorf("%s scored %v, want %v", endpoint.Host, score, 2*factors[proximity[i]])
+				}
+			}
+		})
+	}
+}
+
+func TestGeoIPNearestUnlocatedClient(t *testing.T) {
+	g := openTestGeoIP(t)
+	endpoints, _ := geoTestEndpoints()
+	scores := Scores{endpoints[0]: 2}
+	nearest, biased := g.Nearest(endpoints, scores, netip.MustParseAddr("192.0.2.200"), 3, 0.125)
+	if !slices.Equal(nearest, endpoints) || len(biased) != 1 || biased[endpoints[0]] != 2 {
+		t.Errorf("got %v scored %v, want the endpoints as is", hosts(nearest), biased)
+	}
+}
+
+func TestDERPMapBiasesAllEndpoints(t *testing.T) {
+	d := newTestService()
+	d.geoip = openTestGeoIP(t)
+	d.config.GeoIP.Bias = 0.125
+	var err error
+	if d.grouper, err = NewGrouper(GroupNone, "", d.config.regionIDRange()); err != nil {
+		t.Fatal(err)
+	}
+	if d.scorer, err = NewScorer(ScoreWeights{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	endpoints, _ := geoTestEndpoints()
+	for i, endpoint := range endpoints {
+		endpoint.ID = 901 + i
+	}
+	scores := d.scorer.Scores(endpoints)
+
+	// without nearest every endpoint is served, biased all the same
+	m, err := d.DERPMap(endpoints, &DerpQueryParams{}, geoClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Regions) != len(endpoints) {
+		t.Fatalf("%d regions, want %d", len(m.Regions), len(endpoints))
+	}
+	for _, region := range m.Regions {
+		for _, node := range region.Nodes {
+			if node.HostName == "198.51.100.2" && node.Score != clampScore(scores[endpoints[0]]*0.125) {
+				t.Errorf("%s scored %v, want %v", node.HostName, node.Score, clampScore(scores[endpoints[0]]*0.125))
+			}
+		}
+	}
+}
+
+func hosts(endpoints DerpEndpoints) []string {
+	res := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		res = append(res, endpoint.Host)
+	}
+	return res
+}
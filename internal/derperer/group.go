@@ -0,0 +1,166 @@
+package derperer
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/go-errors/errors"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	GroupNone    = "none"
+	GroupCountry = "country"
+	GroupCity    = "city"
+	GroupASN     = "asn"
+	GroupRule    = "rule"
+)
+
+// Grouper assigns endpoints to multi-node regions.
+type Grouper struct {
+	mode string
+	rule *template.Template
+	ids  IDRange
+}
+
+// NewGrouper returns a grouper for mode, rule is the Go template of the
+// group key of an endpoint for GroupRule. Region IDs of groups are taken
+// from ids.
+func NewGrouper(mode string, rule string, ids IDRange) (*Grouper, error) {
+	g := &Grouper{mode: cmp.Or(mode, GroupNone), ids: ids}
+	switch g.mode {
+	case GroupNone, GroupCountry, GroupCity, GroupASN:
+	case GroupRule:
+		if rule == "" {
+			return nil, errors.Errorf("group rule is required to group by rule")
+		}
+		var err error
+		if g.rule, err = template.New("group").Option("missingkey=zero").Parse(rule); err != nil {
+			return nil, errors.Errorf("parse group rule: %w", err)
+		}
+	default:
+		return nil, errors.Errorf("unknown group mode %q", mode)
+	}
+	return g, nil
+}
+
+// Key returns the group of the endpoint, endpoints with an empty key are not
+// grouped.
+func (g *Grouper) Key(endpoint *DerpEndpoint) string {
+	switch g.mode {
+	case GroupCountry:
+		return endpoint.Country
+	case GroupCity:
+		if endpoint.Country == "" || endpoint.City == "" {
+			return ""
+		}
+		return endpoint.Country + "-" + endpoint.City
+	case GroupASN:
+		return endpoint.ASN
+	case GroupRule:
+		var sb strings.Builder
+		if err := g.rule.Execute(&sb, endpoint); err != nil {
+			return ""
+		}
+		return strings.TrimSpace(sb.String())
+	default:
+		return ""
+	}
+}
+
+// Convert builds a DERP map with a region per group. Nodes of a region are
+// ordered from best to worst, so clients fail over to the next best node. A
+// region is scored by its best scored node.
+func (g *Grouper) Convert(endpoints DerpEndpoints, scores Scores) *DERPMap {
+	if g.mode == GroupNone {
+		return endpoints.Convert(scores)
+	}
+
+	groups := map[string]DerpEndpoints{}
+	var keys []string
+	used := map[int]bool{}
+	var ungrouped DerpEndpoints
+	for _, endpoint := range endpoints {
+		key := g.Key(endpoint)
+		if key == "" {
+			ungrouped = append(ungrouped, endpoint)
+			used[endpoint.ID] = true
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], endpoint)
+	}
+
+	if ungrouped == nil {
+		ungrouped = DerpEndpoints{}
+	}
+	m := ungrouped.Convert(scores)
+	// assign IDs in a fixed order, so that colliding groups keep their IDs
+	slices.Sort(keys)
+	for _, key := range keys {
+		id, err := stableRegionID(key, 0, g.ids.Min, g.ids.Max, used)
+		if err != nil {
+			continue
+		}
+		used[id] = true
+
+		members := groups[key]
+		slices.SortStableFunc(members, compareEndpoints)
+		region := &DERPRegion{
+			DERPRegion: tailcfg.DERPRegion{
+				RegionID:   id,
+				RegionCode: key,
+				RegionName: key,
+			},
+		}
+		for _, endpoint := range members {
+			node := endpoint.node(id)
+			if score, ok := scores[endpoint]; ok {
+				node.Score = score
+				if region.Score == 0 || score < region.Score {
+					region.Score = score
+				}
+			}
+			region.Nodes = append(region.Nodes, node)
+		}
+		m.Regions[id] = region
+		if region.Score != 0 {
+			m.HomeParams.RegionScore[id] = region.Score
+		}
+	}
+	return m
+}
+
+// compareEndpoints orders available endpoints first, then by latency.
+func compareEndpoints(a, b *DerpEndpoint) int {
+	if (a.Status == DerpStatusAvailable) != (b.Status == DerpStatusAvailable) {
+		if a.Status == DerpStatusAvailable {
+			return -1
+		}
+		return 1
+	}
+	return cmp.Compare(a.Latency, b.Latency)
+}
+
+// DERPMap converts endpoints to a DERP map grouped by params.Group, or the
+// configured grouping if it is empty. With a GeoIP database the map is
+// tailored to the client, see GeoIP.Nearest.
+func (d *DerpererService) DERPMap(endpoints DerpEndpoints, params *DerpQueryParams, client netip.Addr) (*DERPMap, error) {
+	grouper := d.grouper
+	if params.Group != "" {
+		var err error
+		if grouper, err = NewGrouper(params.Group, d.config.GroupRule, d.config.regionIDRange()); err != nil {
+			return nil, err
+		}
+	}
+	scores := d.scorer.Scores(endpoints)
+	if d.geoip != nil && client.IsValid() {
+		endpoints, scores = d.geoip.Nearest(endpoints, scores, client, cmp.Or(params.Nearest, d.config.GeoIP.Nearest), d.config.GeoIP.Bias)
+	}
+	return grouper.Convert(endpoints, scores), nil
+}
@@ -0,0 +1,156 @@
+package derperer
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func groupTestEndpoints() DerpEndpoints {
+	endpoint := func(id int, host string, country, city, asn string, latency time.Duration) *DerpEndpoint {
+		return &DerpEndpoint{
+			ID:      id,
+			Name:    host,
+			Host:    host,
+			Port:    443,
+			Country: country,
+			City:    city,
+			ASN:     asn,
+			Status:  DerpStatusAvailable,
+			Latency: latency,
+		}
+	}
+	return DerpEndpoints{
+		endpoint(901, "tokyo-1", "JP", "Tokyo", "AS64496", 30*time.Millisecond),
+		endpoint(902, "tokyo-2", "JP", "Tokyo", "AS64497", 10*time.Millisecond),
+		endpoint(903, "osaka", "JP", "Osaka", "AS64496", 20*time.Millisecond),
+		endpoint(904, "berlin", "DE", "Berlin", "AS64497", 50*time.Millisecond),
+		endpoint(905, "unknown", "", "", "", 40*time.Millisecond),
+	}
+}
+
+// regionNodes returns the hosts of the nodes of each region by region code.
+func regionNodes(m *DERPMap) map[string][]string {
+	res := map[string][]string{}
+	for _, region := range m.Regions {
+		for _, node := range region.Nodes {
+			res[region.RegionCode] = append(res[region.RegionCode], node.HostName)
+		}
+	}
+	return res
+}
+
+func TestGrouperConvert(t *testing.T) {
+	ids := IDRange{Min: 900, Max: 999}
+	for _, tt := range []struct {
+		mode string
+		rule string
+		want map[string][]string
+	}{
+		{GroupNone, "", map[string][]string{
+			"tokyo-1": {"tokyo-1"}, "tokyo-2": {"tokyo-2"}, "osaka": {"osaka"}, "berlin": {"berlin"}, "unknown": {"unknown"},
+		}},
+		{GroupCountry, "", map[string][]string{
+			// nodes are ordered by latency
+			"JP": {"tokyo-2", "osaka", "tokyo-1"}, "DE": {"berlin"}, "unknown": {"unknown"},
+		}},
+		{GroupCity, "", map[string][]string{
+			"JP-Tokyo": {"tokyo-2", "tokyo-1"}, "JP-Osaka": {"osaka"}, "DE-Berlin": {"berlin"}, "unknown": {"unknown"},
+		}},
+		{GroupASN, "", map[string][]string{
+			"AS64496": {"osaka", "tokyo-1"}, "AS64497": {"tokyo-2", "berlin"}, "unknown": {"unknown"},
+		}},
+		{GroupRule, `{{if eq .Country "JP"}}asia{{else if .Country}}europe{{end}}`, map[string][]string{
+			"asia": {"tokyo-2", "osaka", "tokyo-1"}, "europe": {"berlin"}, "unknown": {"unknown"},
+		}},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			g, err := NewGrouper(tt.mode, tt.rule, ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := g.Convert(groupTestEndpoints(), nil)
+			got := regionNodes(m)
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got regions %v, want %v", got, tt.want)
+			}
+			for id, region := range m.Regions {
+				if region.RegionID != id || id < ids.Min || id > ids.Max {
+					t.Errorf("region %s has ID %d under %d, want within [%d, %d]", region.RegionCode, region.RegionID, id, ids.Min, ids.Max)
+				}
+				for _, node := range region.Nodes {
+					if node.RegionID != id {
+						t.Errorf("node %s of region %d has region ID %d", node.HostName, id, node.RegionID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGrouperStableIDs(t *testing.T) {
+	g, err := NewGrouper(GroupCountry, "", IDRange{Min: 900, Max: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regionIDs := func(m *DERPMap) map[string]int {
+		res := map[string]int{}
+		for id, region := range m.Regions {
+			res[region.RegionCode] = id
+		}
+		return res
+	}
+
+	endpoints := groupTestEndpoints()
+	want := regionIDs(g.Convert(endpoints, nil))
+	// neither the order of the endpoints nor other groups change the IDs
+	reversed := slices.Clone(endpoints)
+	slices.Reverse(reversed)
+	reversed = append(reversed, &DerpEndpoint{ID: 906, Host: "paris", Port: 443, Country: "FR", Status: DerpStatusAvailable})
+	got := regionIDs(g.Convert(reversed, nil))
+	for code, id := range want {
+		if got[code] != id {
+			t.Errorf("region %s moved from %d to %d", code, id, got[code])
+		}
+	}
+}
+
+func TestGrouperFoldsScores(t *testing.T) {
+	g, err := NewGrouper(GroupCountry, "", IDRange{Min: 900, Max: 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := groupTestEndpoints()
+	// osaka is unscored
+	scores := Scores{endpoints[0]: 1.5, endpoints[1]: 0.8, endpoints[3]: 2}
+	m := g.Convert(endpoints, scores)
+
+	for id, region := range m.Regions {
+		var want float64
+		switch region.RegionCode {
+		case "JP":
+			// the best node
+			want = 0.8
+		case "DE":
+			want = 2
+		}
+		if region.Score != want {
+			t.Errorf("region %s scored %v, want %v", region.RegionCode, region.Score, want)
+		}
+		if score, ok := m.HomeParams.RegionScore[id]; ok != (want != 0) || score != want {
+			t.Errorf("home params score region %s with %v (%t), want %v", region.RegionCode, score, ok, want)
+		}
+		for _, node := range region.Nodes {
+			var wantNode float64
+			for endpoint, score := range scores {
+				if endpoint.Host == node.HostName {
+					wantNode = score
+				}
+			}
+			if node.Score != wantNode {
+				t.Errorf("node %s scored %v, want %v", node.HostName, node.Score, wantNode)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package derperer
+
+// HeadscaleDERPMap is a DERP map in the YAML format of Headscale's
+// derp.urls and derp.paths.
+type HeadscaleDERPMap struct {
+	Regions map[int]*HeadscaleDERPRegion `yaml:"regions"`
+}
+
+type HeadscaleDERPRegion struct {
+	RegionID   int                  `yaml:"regionid"`
+	RegionCode string               `yaml:"regioncode"`
+	RegionName string               `yaml:"regionname,omitempty"`
+	Nodes      []*HeadscaleDERPNode `yaml:"nodes"`
+}
+
+type HeadscaleDERPNode struct {
+	Name             string `yaml:"name"`
+	RegionID         int    `yaml:"regionid"`
+	HostName         string `yaml:"hostname"`
+	IPv4             string `yaml:"ipv4,omitempty"`
+	IPv6             string `yaml:"ipv6,omitempty"`
+	STUNPort         int    `yaml:"stunport"`
+	STUNOnly         bool   `yaml:"stunonly,omitempty"`
+	DERPPort         int    `yaml:"derpport"`
+	InsecureForTests bool   `yaml:"insecurefortests"`
+}
+
+// Headscale converts the map to the Headscale format, which has no home
+// params or check results.
+func (m *DERPMap) Headscale() *HeadscaleDERPMap {
+	h := &HeadscaleDERPMap{Regions: make(map[int]*HeadscaleDERPRegion, len(m.Regions))}
+	for id, region := range m.Regions {
+		r := &HeadscaleDERPRegion{
+			RegionID:   region.RegionID,
+			RegionCode: region.RegionCode,
+			RegionName: region.RegionName,
+			Nodes:      make([]*HeadscaleDERPNode, 0, len(region.Nodes)),
+		}
+		for _, node := range region.Nodes {
+			r.Nodes = append(r.Nodes, &HeadscaleDERPNode{
+				Name:             node.Name,
+				RegionID:         node.RegionID,
+				HostName:         node.HostName,
+				IPv4:             node.IPv4,
+				IPv6:             node.IPv6,
+				STUNPort:         node.STUNPort,
+				STUNOnly:         node.STUNOnly,
+				DERPPort:         node.DERPPort,
+				InsecureForTests: node.InsecureForTests,
+			})
+		}
+		h.Regions[id] = r
+	}
+	return h
+}
@@ -0,0 +1,59 @@
+package derperer
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"tailscale.com/tailcfg"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestHeadscaleGolden(t *testing.T) {
+	endpoints := DerpEndpoints{
+		{ID: 901, Name: "JP-Tokyo", Host: "derp.example.com", IPv4: "192.0.2.1", IPv6: "2001:db8::1", Port: 443, Country: "JP", Status: DerpStatusAvailable, Latency: 10 * time.Millisecond},
+		{ID: 902, Name: "DE-Berlin", Host: "198.51.100.1", IPv4: "198.51.100.1", Port: 8443, Insecure: true, Country: "DE", Status: DerpStatusAvailable, Latency: 30 * time.Millisecond},
+	}
+	data, err := yaml.Marshal(endpoints.Convert(Scores{endpoints[0]: 0.5}).Headscale())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "headscale.yaml")
+	if *update {
+		if err := os.WriteFile(golden, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+
+	// Headscale decodes its derp.paths with yaml into a tailcfg.DERPMap,
+	// whose fields are matched lowercased
+	var m tailcfg.DERPMap
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Regions) != 2 {
+		t.Fatalf("decoded %d regions, want 2", len(m.Regions))
+	}
+	tokyo := m.Regions[901]
+	if tokyo == nil || tokyo.RegionID != 901 || tokyo.RegionCode != "JP-Tokyo" || len(tokyo.Nodes) != 1 {
+		t.Fatalf("decoded region %+v", tokyo)
+	}
+	if node := tokyo.Nodes[0]; node.Name != "JP-Tokyo" || node.RegionID != 901 || node.HostName != "derp.example.com" || node.IPv4 != "192.0.2.1" || node.IPv6 != "2001:db8::1" || node.DERPPort != 443 {
+		t.Errorf("decoded node %+v", node)
+	}
+	if node := m.Regions[902].Nodes[0]; node.DERPPort != 8443 || !node.InsecureForTests {
+		t.Errorf("decoded node %+v", node)
+	}
+}
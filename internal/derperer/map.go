@@ -1,17 +1,18 @@
 package derperer
 
 import (
+	"cmp"
 	"context"
-	"fmt"
+	"maps"
 	"net"
-	"strconv"
-	"sync/atomic"
+	"net/netip"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/sourcegraph/conc"
 	"github.com/sourcegraph/conc/pool"
 	"github.com/yoshino-s/derperer/pkg/speedtest"
-	"github.com/yoshino-s/go-app/fofa"
 	"github.com/yoshino-s/go-framework/application"
 	"github.com/yoshino-s/go-framework/configuration"
 	"go.uber.org/zap"
@@ -21,21 +22,32 @@ type DerpererService struct {
 	*application.EmptyApplication
 	config config
 
-	DerpEndpoints DerpEndpoints
+	Registry *Registry
 
-	nextRegionID *atomic.Int32
+	sources []DiscoverySource
+	sinks   []Sink
+	store   Store
+	status  status
+	grouper *Grouper
+	scorer  *Scorer
+	geoip   *GeoIP
+
+	// unpersisted holds the latest change of each endpoint not written to
+	// the store yet, persisted is signalled when it is added to.
+	unpersisted   map[string]Event
+	unpersistedMu sync.Mutex
+	persisted     chan struct{}
 
 	SpeedtestService *speedtest.SpeedTestService `inject:""`
-	Fofa             *fofa.FofaApp               `inject:""`
 }
 
 func New() *DerpererService {
-	nextRegionID := &atomic.Int32{}
-	nextRegionID.Store(900)
+	registry := NewRegistry()
+	registry.Subscribe(newEndpointMetrics().observe)
 
 	return &DerpererService{
 		EmptyApplication: application.NewEmptyApplication("Derperer"),
-		nextRegionID:     nextRegionID,
+		Registry:         registry,
 	}
 }
 
@@ -43,18 +55,145 @@ func (d *DerpererService) Configuration() configuration.Configuration {
 	return &d.config
 }
 
-func (d *DerpererService) testDerpEndpoint(endpoint *DerpEndpoint) {
-	res, err := d.SpeedtestService.CheckDerp(endpoint.Convert().ToOriginal(), d.config.CheckDuration)
+func (d *DerpererService) Setup(ctx context.Context) {
+	grouper, err := NewGrouper(d.config.GroupBy, d.config.GroupRule, d.config.regionIDRange())
+	if err != nil {
+		d.Logger.Fatal("invalid derperer.group_by", zap.Error(err))
+	}
+	d.grouper = grouper
+	scorer, err := NewScorer(d.config.Score.ScoreWeights, d.config.Score.Preferences)
+	if err != nil {
+		d.Logger.Fatal("invalid derperer.score.preferences", zap.Error(err))
+	}
+	d.scorer = scorer
+	if len(d.config.GeoIP.Databases) > 0 {
+		if d.geoip, err = OpenGeoIP(d.config.GeoIP.Databases); err != nil {
+			d.Logger.Fatal("failed to open geoip databases", zap.Error(err))
+		}
+	}
+
+	if d.config.Storage == "" {
+		return
+	}
+	store, err := OpenStore(d.config.Storage)
+	if err != nil {
+		d.Logger.Fatal("failed to open storage", zap.Error(err))
+	}
+	d.store = store
+	d.unpersisted = map[string]Event{}
+	d.persisted = make(chan struct{}, 1)
+
+	d.Registry.Subscribe(d.queuePersist)
+
+	endpoints, err := store.Load()
+	if err != nil {
+		d.Logger.Fatal("failed to load endpoints", zap.Error(err))
+	}
+	for _, endpoint := range endpoints {
+		// stored before discovery times were recorded
+		if endpoint.DiscoveredAt.IsZero() {
+			endpoint.DiscoveredAt = time.Now()
+		}
+	}
+	if err := d.Registry.Load(endpoints, d.config.regionIDRange()); err != nil {
+		d.Logger.Fatal("failed to assign region ids", zap.Error(err))
+	}
+	d.Logger.Info("loaded endpoints from storage", zap.Int("count", len(endpoints)), zap.String("path", d.config.Storage))
+}
+
+func (d *DerpererService) Close(ctx context.Context) {
+	if d.geoip != nil {
+		if err := d.geoip.Close(); err != nil {
+			d.Logger.Error("failed to close geoip databases", zap.Error(err))
+		}
+	}
+	if d.store == nil {
+		return
+	}
+	d.persist()
+	if err := d.store.Close(); err != nil {
+		d.Logger.Error("failed to close storage", zap.Error(err))
+	}
+}
+
+// queuePersist queues a change for persistLoop, it runs as a registry
+// listener so it must not wait for the store.
+func (d *DerpererService) queuePersist(event Event) {
+	d.unpersistedMu.Lock()
+	d.unpersisted[string(endpointKey(event.Endpoint.Host, event.Endpoint.Port))] = event
+	d.unpersistedMu.Unlock()
+	select {
+	case d.persisted <- struct{}{}:
+	default:
+	}
+}
+
+// persistLoop writes the queued changes to the store until ctx is done.
+// Changes made while a write is running are written together with the next
+// one, so a check cycle doesn't sync the store for every result.
+func (d *DerpererService) persistLoop(ctx context.Context) {
+	for {
+		select {
+		case <-d.persisted:
+			d.persist()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// persist writes the queued changes to the store. Changes which failed to
+// be written are queued again, unless the endpoint changed since.
+func (d *DerpererService) persist() {
+	d.unpersistedMu.Lock()
+	pending := d.unpersisted
+	d.unpersisted = map[string]Event{}
+	d.unpersistedMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := d.store.Write(slices.Collect(maps.Values(pending))); err != nil {
+		d.Logger.Error("failed to persist endpoints", zap.Int("count", len(pending)), zap.Error(err))
+		d.unpersistedMu.Lock()
+		for key, event := range pending {
+			if _, ok := d.unpersisted[key]; !ok {
+				d.unpersisted[key] = event
+			}
+		}
+		d.unpersistedMu.Unlock()
+	}
+}
+
+// AddSource registers a discovery source, it must be called before Run.
+func (d *DerpererService) AddSource(source DiscoverySource) {
+	d.sources = append(d.sources, source)
+}
+
+func (d *DerpererService) testDerpEndpoint(ctx context.Context, endpoint *DerpEndpoint) {
+	start := time.Now()
+	res, err := d.SpeedtestService.CheckDerp(ctx, endpoint.Convert().ToOriginal(), d.config.checkOptions())
+	if ctx.Err() != nil {
+		// the service is shutting down, the check says nothing about the endpoint
+		return
+	}
 	if err != nil {
 		d.Logger.Error("failed to check derp", zap.Any("endpoint", endpoint), zap.Error(err))
-		endpoint.Latency = 0
-		endpoint.Bandwidth = speedtest.Unit{0, "bps"}
-		endpoint.Error = err.Error()
-		endpoint.Status = DerpStatusError
+		checkDuration.WithLabelValues(string(DerpStatusError)).Observe(time.Since(start).Seconds())
+		checkErrors.WithLabelValues(string(speedtest.ClassOf(err))).Inc()
 	} else {
-		endpoint.Latency = res.Latency
-		endpoint.Bandwidth = res.Bps
-		endpoint.Status = DerpStatusAvailable
+		checkDuration.WithLabelValues(string(DerpStatusAvailable)).Observe(time.Since(start).Seconds())
+	}
+	result := NewCheckResult(res, err)
+	endpoint, _ = d.Registry.Update(endpoint.Host, endpoint.Port, func(endpoint *DerpEndpoint) {
+		endpoint.Timing = res.Timing
+		endpoint.updateUptime(err == nil)
+		endpoint.apply(result)
+		if err == nil {
+			endpoint.LastAvailableAt = result.CheckedAt
+		}
+	})
+	if err == nil {
 		d.Logger.Debug("checked derp", zap.Any("endpoint", endpoint))
 	}
 }
@@ -62,49 +201,152 @@ func (d *DerpererService) testDerpEndpoint(endpoint *DerpEndpoint) {
 func (d *DerpererService) Run(ctx context.Context) {
 	wg := conc.NewWaitGroup()
 	wg.Go(func() { d.recheck(ctx) })
-	wg.Go(func() { d.refetch(ctx) })
+	if d.store != nil {
+		wg.Go(func() { d.persistLoop(ctx) })
+	}
+	for _, source := range d.sources {
+		wg.Go(func() { d.refetch(ctx, source) })
+	}
+	for _, sink := range d.sinks {
+		wg.Go(func() { d.output(ctx, sink) })
+	}
 
 	wg.Wait()
 }
 
-const FINGERPRINT = `body="<h1>DERP</h1>"`
-const FINGERPRIINT_CN = `body="<h1>DERP</h1>" && country="CN"`
+func (d *DerpererService) sourceOptions(source DiscoverySource) SourceOptions {
+	opts := SourceOptions{
+		Interval: d.config.RefetchInterval,
+		Limit:    d.config.FetchLimit,
+	}
+	if s, ok := source.(ScheduledSource); ok {
+		o := s.SourceOptions()
+		if o.Interval != 0 {
+			opts.Interval = o.Interval
+		}
+		if o.Limit != 0 {
+			opts.Limit = o.Limit
+		}
+	}
+	return opts
+}
+
+func (d *DerpererService) refetch(ctx context.Context, source DiscoverySource) {
+	opts := d.sourceOptions(source)
 
-func (d *DerpererService) refetch(ctx context.Context) {
-	t := time.After(0)
+	var changes <-chan struct{}
+	if s, ok := source.(WatchedSource); ok {
+		changes = s.Changes(ctx)
+	}
+
+	t := time.After(d.firstFetchDelay(source, opts.Interval))
 	for {
 		select {
 		case <-t:
-			page := 1
-			count := 0
-			for {
-				if count > d.config.FetchLimit {
-					d.Logger.Debug("reach fetch limit")
-					break
-				}
-				d.Logger.Debug("querying fofa", zap.Int("page", page))
-				fingerprint := FINGERPRINT
-				if d.config.CN {
-					fingerprint = FINGERPRIINT_CN
-				}
-				res, err := d.Fofa.Query(fingerprint, page, 100, fofa.WithExtraFields(
-					"country", "region", "city", "as_organization",
-				))
-				if err != nil {
-					d.Logger.Error("failed to fetch derp endpoints from fofa", zap.Error(err))
-					break
-				}
-				for _, asset := range res {
-					d.addDerpEndpoint(asset)
-					count++
-				}
-				page++
-			}
-			t = time.After(d.config.RefetchInterval)
+		case <-changes:
 		case <-ctx.Done():
 			return
 		}
+		d.fetch(ctx, source, opts)
+		t = time.After(opts.Interval)
+	}
+}
+
+func (d *DerpererService) fetch(ctx context.Context, source DiscoverySource, opts SourceOptions) {
+	logger := d.Logger.With(zap.String("source", source.Name()))
+
+	logger.Debug("fetching candidates")
+	candidates, err := source.Fetch(ctx, FetchOptions{
+		Limit:     opts.Limit,
+		ChinaOnly: d.config.CN,
+	})
+	if err != nil {
+		logger.Error("failed to fetch derp endpoints", zap.Error(err))
+	}
+	d.status.fetched(source.Name(), time.Now(), len(candidates), err)
+	sourceFetches.WithLabelValues(source.Name()).Inc()
+	if err != nil {
+		sourceErrors.WithLabelValues(source.Name()).Inc()
+	}
+	pinned := map[string]bool{}
+	for _, candidate := range candidates {
+		candidate.Source = source.Name()
+		if candidate.Pinned {
+			pinned[string(endpointKey(candidate.Host, candidate.Port))] = true
+		}
+		endpoint, err := d.addDerpEndpoint(candidate)
+		if err != nil {
+			logger.Warn("failed to add derp endpoint", zap.String("host", candidate.Host), zap.Error(err))
+			continue
+		}
+		if candidate.Result != nil {
+			d.recordResult(endpoint, PeerVantage(candidate.Peer), candidate.Result)
+		}
+	}
+	if err == nil {
+		d.release(source.Name(), pinned)
+	}
+	if d.store != nil {
+		if err := d.store.SaveLastFetch(source.Name(), time.Now()); err != nil {
+			logger.Error("failed to save last fetch time", zap.Error(err))
+		}
+	}
+}
+
+// release removes endpoints pinned by source which it no longer reports.
+// Pinning sources own their endpoints, e.g. an endpoint deleted from the file
+// of the static source is deleted from the map as well.
+func (d *DerpererService) release(source string, pinned map[string]bool) {
+	for _, endpoint := range d.Registry.Snapshot() {
+		if !endpoint.Pinned || endpoint.Source != source || pinned[string(endpointKey(endpoint.Host, endpoint.Port))] {
+			continue
+		}
+		if _, ok := d.Registry.Remove(endpoint.Host, endpoint.Port); ok {
+			d.Logger.Info("remove endpoint", zap.String("source", source), zap.String("host", endpoint.Host), zap.Int("port", endpoint.Port))
+		}
+	}
+}
+
+// evict removes endpoints which were not available within EvictAfter, pinned
+// endpoints are kept.
+func (d *DerpererService) evict() {
+	if d.config.EvictAfter == 0 {
+		return
+	}
+	for _, endpoint := range d.Registry.Snapshot() {
+		if endpoint.Pinned || endpoint.Status == DerpStatusAvailable {
+			continue
+		}
+		last := endpoint.LastAvailableAt
+		if last.IsZero() {
+			last = endpoint.DiscoveredAt
+		}
+		if time.Since(last) < d.config.EvictAfter {
+			continue
+		}
+		if _, ok := d.Registry.Remove(endpoint.Host, endpoint.Port); ok {
+			d.Logger.Info("evict endpoint", zap.String("host", endpoint.Host), zap.Int("port", endpoint.Port), zap.Time("last_available", endpoint.LastAvailableAt))
+			endpointsEvicted.Inc()
+		}
+	}
+}
+
+// firstFetchDelay resumes the refetch schedule of a source persisted in the
+// storage, so that restarts don't re-query sources which were just fetched.
+func (d *DerpererService) firstFetchDelay(source DiscoverySource, interval time.Duration) time.Duration {
+	if _, ok := source.(WatchedSource); ok || d.store == nil {
+		return 0
+	}
+	last, err := d.store.LastFetch(source.Name())
+	if err != nil {
+		d.Logger.Error("failed to load last fetch time", zap.String("source", source.Name()), zap.Error(err))
+		return 0
 	}
+	if last.IsZero() {
+		return 0
+	}
+	d.status.fetched(source.Name(), last, 0, nil)
+	return max(time.Until(last.Add(interval)), 0)
 }
 
 func (d *DerpererService) recheck(ctx context.Context) {
@@ -113,13 +355,30 @@ func (d *DerpererService) recheck(ctx context.Context) {
 		select {
 		case <-t:
 			d.Logger.Debug("start recheck")
+			start := time.Now()
 			pool := pool.New().WithMaxGoroutines(d.config.CheckConcurrency)
-			for _, endpoint := range d.DerpEndpoints {
+			checkPoolSize.Set(float64(d.config.CheckConcurrency))
+			endpoints := d.Registry.Snapshot()
+			checkPoolPending.Set(float64(len(endpoints)))
+			for _, endpoint := range endpoints {
 				pool.Go(func() {
-					d.testDerpEndpoint(endpoint)
+					checkPoolPending.Dec()
+					if ctx.Err() != nil {
+						return
+					}
+					checkPoolActive.Inc()
+					defer checkPoolActive.Dec()
+					d.testDerpEndpoint(ctx, endpoint)
 				})
 			}
 			pool.Wait()
+			if ctx.Err() != nil {
+				return
+			}
+			d.status.rechecked(time.Now(), time.Since(start))
+			recheckDuration.Set(time.Since(start).Seconds())
+			d.evict()
+			d.expireResults()
 			t = time.After(d.config.RecheckInterval)
 		case <-ctx.Done():
 			return
@@ -127,62 +386,75 @@ func (d *DerpererService) recheck(ctx context.Context) {
 	}
 }
 
-func (m *DerpererService) addDerpEndpoint(asset fofa.Asset) (*DerpEndpoint, error) {
-	host := asset.URL.Hostname()
-	port, err := strconv.Atoi(asset.URL.Port())
-	if err != nil {
-		return nil, err
-	}
+func (m *DerpererService) addDerpEndpoint(candidate *Candidate) (*DerpEndpoint, error) {
+	host := candidate.Host
+	port := candidate.Port
 
-	if exist, ok := m.DerpEndpoints.Exist(host, port); ok {
+	if exist, ok := m.Registry.Get(host, port); ok {
+		if candidate.Pinned && !exist.Pinned {
+			// the pinning source takes over the endpoint
+			exist, _ = m.Registry.Update(host, port, func(endpoint *DerpEndpoint) {
+				endpoint.Pinned = true
+				endpoint.Source = candidate.Source
+			})
+		}
 		return exist, nil
 	}
 
 	node := &DerpEndpoint{
-		Host: host,
-		Port: port,
+		Host:         host,
+		Port:         port,
+		OriginalID:   candidate.RegionID,
+		NodeName:     candidate.NodeName,
+		Source:       candidate.Source,
+		Peer:         candidate.Peer,
+		Pinned:       candidate.Pinned,
+		Insecure:     candidate.Insecure,
+		Country:      candidate.Country,
+		Region:       candidate.Region,
+		City:         candidate.City,
+		ASN:          candidate.ASN,
+		Status:       DerpStatusUnknown,
+		DiscoveredAt: time.Now(),
 	}
 
-	if net.ParseIP(host) == nil {
+	ips := []net.IP{candidate.IP}
+	if net.ParseIP(host) != nil {
+		node.Insecure = true
+		if candidate.IP == nil {
+			ips = []net.IP{net.ParseIP(host)}
+		}
+	} else if candidate.IP == nil {
 		// resolve domain with both ipv4 and ipv6
-		ips, err := net.LookupIP(host)
+		var err error
+		ips, err = net.LookupIP(host)
 		if err != nil {
 			return nil, err
 		}
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				node.IPv4 = ip.String()
-			} else {
-				node.IPv6 = ip.String()
-			}
-		}
-	} else {
-		node.Insecure = true
-		if asset.IP.To4() != nil {
-			node.IPv4 = asset.IP.String()
+	}
+	// a hostname with the IP reported by the source is pinned to that IP,
+	// the certificate is still verified against the hostname
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			node.IPv4 = ip.String()
 		} else {
-			node.IPv6 = asset.IP.String()
+			node.IPv6 = ip.String()
 		}
 	}
 
-	regionID := m.nextRegionID.Load()
-	m.nextRegionID.Add(1)
-
-	code := asset.Raw["country"]
-	if asset.Raw["region"] != "" {
-		code += fmt.Sprintf("-%s", asset.Raw["region"])
-	}
-	if asset.Raw["city"] != "" {
-		code += fmt.Sprintf("-%s", asset.Raw["city"])
-	}
-	if asset.Raw["as_organization"] != "" {
-		code += fmt.Sprintf("-%s", asset.Raw["as_organization"])
+	// fill in the location the source didn't report, e.g. the ASN of Hunter
+	if m.geoip != nil {
+		if addr, err := netip.ParseAddr(cmp.Or(node.IPv4, node.IPv6)); err == nil {
+			if loc := m.geoip.Lookup(addr); loc != nil {
+				node.Country = cmp.Or(node.Country, loc.Country)
+				node.City = cmp.Or(node.City, loc.City)
+				node.ASN = cmp.Or(node.ASN, loc.ASN)
+			}
+		}
 	}
-	code += fmt.Sprintf("-%s", asset.Raw["ip"])
-	node.Name = code
 
-	node.ID = int(regionID)
+	node.Name = candidate.Code()
 
-	m.DerpEndpoints = append(m.DerpEndpoints, node)
-	return node, nil
+	node, _, err := m.Registry.Add(node, m.config.regionIDRange())
+	return node, err
 }
@@ -0,0 +1,91 @@
+package derperer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yoshino-s/go-framework/application"
+)
+
+type fakeSource struct {
+	name       string
+	candidates []*Candidate
+	err        error
+}
+
+func (s *fakeSource) Name() string {
+	return s.name
+}
+
+func (s *fakeSource) Fetch(context.Context, FetchOptions) ([]*Candidate, error) {
+	return s.candidates, s.err
+}
+
+func newTestService() *DerpererService {
+	return &DerpererService{
+		EmptyApplication: application.NewEmptyApplication("DerpererService"),
+		config:           config{RegionIDMin: 900, RegionIDMax: 999},
+		Registry:         NewRegistry(),
+	}
+}
+
+func TestFetchRemovesUnlistedPinnedEndpoints(t *testing.T) {
+	d := newTestService()
+	static := &fakeSource{name: "static", candidates: []*Candidate{
+		{Host: "192.0.2.1", Port: 443, Pinned: true},
+		{Host: "192.0.2.2", Port: 443, Pinned: true},
+	}}
+	scan := &fakeSource{name: "scan", candidates: []*Candidate{
+		{Host: "192.0.2.3", Port: 443},
+	}}
+	d.fetch(context.Background(), static, SourceOptions{})
+	d.fetch(context.Background(), scan, SourceOptions{})
+	// an available endpoint is removed as well
+	d.Registry.Update("192.0.2.2", 443, func(endpoint *DerpEndpoint) {
+		endpoint.Status = DerpStatusAvailable
+	})
+
+	static.candidates = static.candidates[:1]
+	d.fetch(context.Background(), static, SourceOptions{})
+	if _, ok := d.Registry.Get("192.0.2.2", 443); ok {
+		t.Error("endpoint removed from the static source is still in the map")
+	}
+	if _, ok := d.Registry.Get("192.0.2.1", 443); !ok {
+		t.Error("endpoint still listed by the static source was removed")
+	}
+
+	static.candidates = nil
+	d.fetch(context.Background(), static, SourceOptions{})
+	if _, ok := d.Registry.Get("192.0.2.3", 443); !ok {
+		t.Error("endpoint of another source was removed")
+	}
+	if n := len(d.Registry.Snapshot()); n != 1 {
+		t.Errorf("%d endpoints left, want 1", n)
+	}
+}
+
+func TestFetchKeepsImportedNodeNames(t *testing.T) {
+	d := newTestService()
+	imported := &fakeSource{name: "derpmap", candidates: []*Candidate{
+		{Host: "192.0.2.1", Port: 443, Pinned: true, RegionID: 1, RegionCode: "nyc", NodeName: "1a"},
+		{Host: "192.0.2.2", Port: 443, Pinned: true, NodeName: "1b"},
+	}}
+	d.fetch(context.Background(), imported, SourceOptions{})
+
+	m := d.Registry.Snapshot().Convert(nil)
+	regions := map[string]string{}
+	for _, region := range m.Regions {
+		for _, node := range region.Nodes {
+			regions[node.Name] = region.RegionCode
+		}
+	}
+	if len(regions) != 2 {
+		t.Fatalf("nodes %v, want 1a and 1b", regions)
+	}
+	if regions["1a"] != "nyc" {
+		t.Errorf("node 1a in region %q, want nyc", regions["1a"])
+	}
+	if code, ok := regions["1b"]; !ok || code == "nyc" {
+		t.Errorf("node 1b in region %q, want its own", code)
+	}
+}
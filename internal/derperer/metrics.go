@@ -0,0 +1,150 @@
+package derperer
+
+import (
+	"maps"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "derperer"
+
+var endpointLabelNames = []string{"region_id", "host", "country"}
+
+var (
+	endpointLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_latency_seconds",
+		Help:      "Latency measured by the last check of the endpoint.",
+	}, endpointLabelNames)
+	endpointBandwidth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_bandwidth_bits_per_second",
+		Help:      "Bandwidth measured by the last check of the endpoint.",
+	}, endpointLabelNames)
+	endpointUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_up",
+		Help:      "Whether the last check of the endpoint succeeded, 1 for available and 0 otherwise.",
+	}, endpointLabelNames)
+	endpointLastCheck = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_last_check_timestamp_seconds",
+		Help:      "Unix time of the last check of the endpoint.",
+	}, endpointLabelNames)
+
+	// SourceQueries counts requests sent by discovery sources to their
+	// upstream API, e.g. one per FOFA result page.
+	SourceQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "source_queries_total",
+		Help:      "Number of queries sent by discovery sources.",
+	}, []string{"source"})
+	sourceFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "source_fetches_total",
+		Help:      "Number of discovery runs of each source.",
+	}, []string{"source"})
+	sourceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "source_errors_total",
+		Help:      "Number of failed discovery runs of each source.",
+	}, []string{"source"})
+
+	checkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_duration_seconds",
+		Help:      "Duration of endpoint checks.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 8),
+	}, []string{"status"})
+	checkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_errors_total",
+		Help:      "Number of failed endpoint checks by error class.",
+	}, []string{"class"})
+	recheckDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "recheck_duration_seconds",
+		Help:      "Duration of the last recheck cycle.",
+	})
+	endpointsEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoints_evicted_total",
+		Help:      "Number of endpoints removed by eviction.",
+	})
+	outputPushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "output_pushes_total",
+		Help:      "Number of DERP maps pushed to an output sink.",
+	}, []string{"sink"})
+	outputErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "output_errors_total",
+		Help:      "Number of failed pushes to an output sink.",
+	}, []string{"sink"})
+	checkPoolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_pool_size",
+		Help:      "Maximum number of concurrent checks.",
+	})
+	checkPoolActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_pool_active",
+		Help:      "Number of checks currently running.",
+	})
+	checkPoolPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_pool_pending",
+		Help:      "Number of endpoints waiting for a free check slot in the current recheck cycle.",
+	})
+)
+
+// endpointMetrics mirrors the registry into the per endpoint gauges, it is
+// a registry listener so calls are serialised.
+type endpointMetrics struct {
+	labels map[string]prometheus.Labels
+}
+
+func newEndpointMetrics() *endpointMetrics {
+	return &endpointMetrics{
+		labels: map[string]prometheus.Labels{},
+	}
+}
+
+func (m *endpointMetrics) delete(labels prometheus.Labels) {
+	endpointLatency.Delete(labels)
+	endpointBandwidth.Delete(labels)
+	endpointUp.Delete(labels)
+	endpointLastCheck.Delete(labels)
+}
+
+func (m *endpointMetrics) observe(event Event) {
+	endpoint := event.Endpoint
+	key := string(endpointKey(endpoint.Host, endpoint.Port))
+	labels := prometheus.Labels{
+		"region_id": strconv.Itoa(endpoint.ID),
+		"host":      endpoint.Host,
+		"country":   endpoint.Country,
+	}
+
+	if old, ok := m.labels[key]; ok && (event.Type == EventRemoved || !maps.Equal(old, labels)) {
+		m.delete(old)
+		delete(m.labels, key)
+	}
+	if event.Type == EventRemoved {
+		return
+	}
+	m.labels[key] = labels
+
+	endpointLatency.With(labels).Set(endpoint.Latency.Seconds())
+	endpointBandwidth.With(labels).Set(endpoint.Bandwidth.Value)
+	up := 0.0
+	if endpoint.Status == DerpStatusAvailable {
+		up = 1
+	}
+	endpointUp.With(labels).Set(up)
+	if !endpoint.CheckedAt.IsZero() {
+		endpointLastCheck.With(labels).Set(float64(endpoint.CheckedAt.Unix()))
+	}
+}
@@ -0,0 +1,86 @@
+package derperer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+)
+
+func TestSourceMetrics(t *testing.T) {
+	d := newTestService()
+	source := &fakeSource{name: "metrics"}
+	fetches := testutil.ToFloat64(sourceFetches.WithLabelValues(source.name))
+	errs := testutil.ToFloat64(sourceErrors.WithLabelValues(source.name))
+
+	d.fetch(context.Background(), source, SourceOptions{})
+	source.err = errors.New("quota exceeded")
+	d.fetch(context.Background(), source, SourceOptions{})
+
+	if n := testutil.ToFloat64(sourceFetches.WithLabelValues(source.name)) - fetches; n != 2 {
+		t.Errorf("%v fetches counted, want 2", n)
+	}
+	if n := testutil.ToFloat64(sourceErrors.WithLabelValues(source.name)) - errs; n != 1 {
+		t.Errorf("%v errors counted, want 1", n)
+	}
+}
+
+func TestRecheckMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	d := newTestService()
+	d.SpeedtestService = speedtest.New()
+	d.config.CheckConcurrency = 3
+	d.config.ConnectTimeout = time.Second
+	d.config.HandshakeTimeout = time.Second
+	d.config.CheckDuration = time.Second
+	d.config.RecheckInterval = time.Hour
+	d.config.EvictAfter = time.Nanosecond
+	d.Registry.Add(&DerpEndpoint{Host: "127.0.0.1", Port: port, Status: DerpStatusUnknown}, d.config.regionIDRange())
+
+	dialErrors := testutil.ToFloat64(checkErrors.WithLabelValues(string(speedtest.ErrorClassDial)))
+	evicted := testutil.ToFloat64(endpointsEvicted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.recheck(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.status.mu.Lock()
+		cycles := d.status.recheckCycles
+		d.status.mu.Unlock()
+		if cycles > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no recheck cycle finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the refused endpoint failed its check and was evicted
+	if n := testutil.ToFloat64(checkErrors.WithLabelValues(string(speedtest.ErrorClassDial))) - dialErrors; n != 1 {
+		t.Errorf("%v dial errors counted, want 1", n)
+	}
+	if n := testutil.ToFloat64(endpointsEvicted) - evicted; n != 1 {
+		t.Errorf("%v endpoints evicted, want 1", n)
+	}
+	if n := testutil.ToFloat64(checkPoolSize); n != 3 {
+		t.Errorf("check pool size %v, want 3", n)
+	}
+	if n := testutil.ToFloat64(checkPoolPending); n != 0 {
+		t.Errorf("%v checks pending after the cycle, want 0", n)
+	}
+	if n := testutil.ToFloat64(recheckDuration); n <= 0 {
+		t.Errorf("recheck duration %v, want more than 0", n)
+	}
+}
@@ -0,0 +1,232 @@
+package derperer
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"math"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+	FormatPolicy = "policy"
+)
+
+// Sink receives the DERP map whenever it changes materially.
+type Sink interface {
+	Name() string
+	SinkOptions() SinkOptions
+	Push(ctx context.Context, data []byte, contentType string) error
+}
+
+type SinkOptions struct {
+	// Query filters the map like the query parameters of /derp.json.
+	Query *DerpQueryParams
+	// Format is one of FormatJSON, FormatYAML and FormatPolicy.
+	Format string
+	// Debounce is the time to wait for further changes before pushing.
+	Debounce time.Duration
+}
+
+func (d *DerpererService) AddSink(sink Sink) {
+	d.sinks = append(d.sinks, sink)
+}
+
+// ParseDerpQuery parses the query parameters of /derp.json.
+func ParseDerpQuery(query string) (*DerpQueryParams, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	params := &DerpQueryParams{
+		Status:         DerpStatus(values.Get("status")),
+		BandwidthLimit: values.Get("bandwidth-limit"),
+		ErrorClass:     values.Get("error-class"),
+		Vantage:        values.Get("vantage"),
+		Group:          values.Get("group"),
+		Client:         values.Get("client"),
+	}
+	if v := values.Get("latency-limit"); v != "" {
+		if params.LatencyLimit, err = time.ParseDuration(v); err != nil {
+			return nil, errors.Errorf("invalid latency-limit: %w", err)
+		}
+	}
+	if v := values.Get("nearest"); v != "" {
+		if params.Nearest, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Errorf("invalid nearest: %w", err)
+		}
+	}
+	return params, nil
+}
+
+// RenderDERPMap renders the map in format and returns its content type.
+func RenderDERPMap(m *DERPMap, format string) ([]byte, string, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.Marshal(m)
+		return data, "application/json", err
+	case FormatYAML:
+		data, err := yaml.Marshal(m.Headscale())
+		return data, "application/yaml", err
+	case FormatPolicy:
+		data, err := PolicyFragment(m.Policy(false))
+		return data, "application/hujson", err
+	default:
+		return nil, "", errors.Errorf("unknown format %q", format)
+	}
+}
+
+// scoreChange is the relative change of a region score which is material.
+const scoreChange = 0.25
+
+type materialNode struct {
+	Host     string     `json:"host"`
+	Port     int        `json:"port"`
+	IPv4     string     `json:"ipv4"`
+	IPv6     string     `json:"ipv6"`
+	Insecure bool       `json:"insecure"`
+	Status   DerpStatus `json:"status,omitempty"`
+}
+
+type materialRegion struct {
+	Code  string         `json:"code"`
+	Nodes []materialNode `json:"nodes"`
+}
+
+// material is what consumers of a map act on, its regions and nodes and, if
+// measurements are part of the output, node statuses and region scores.
+// Nodes reordered by latency jitter alone are no material change.
+type material struct {
+	key    string
+	scores map[int]float64
+}
+
+func newMaterial(m *DERPMap, measurements bool) *material {
+	regions := make(map[int]materialRegion, len(m.Regions))
+	scores := map[int]float64{}
+	for id, region := range m.Regions {
+		r := materialRegion{Code: region.RegionCode}
+		for _, node := range region.Nodes {
+			n := materialNode{
+				Host:     node.HostName,
+				Port:     node.DERPPort,
+				IPv4:     node.IPv4,
+				IPv6:     node.IPv6,
+				Insecure: node.InsecureForTests,
+			}
+			if measurements {
+				n.Status = node.Status
+			}
+			r.Nodes = append(r.Nodes, n)
+		}
+		slices.SortFunc(r.Nodes, func(a, b materialNode) int {
+			return cmp.Or(cmp.Compare(a.Host, b.Host), cmp.Compare(a.Port, b.Port))
+		})
+		regions[id] = r
+		if measurements && region.Score != 0 {
+			scores[id] = region.Score
+		}
+	}
+	data, _ := json.Marshal(regions)
+	return &material{key: string(data), scores: scores}
+}
+
+// changed reports whether the map differs materially from last, a region
+// score changes materially if it moves by more than scoreChange.
+func (m *material) changed(last *material) bool {
+	if last == nil || m.key != last.key || len(m.scores) != len(last.scores) {
+		return true
+	}
+	for id, score := range m.scores {
+		previous, ok := last.scores[id]
+		if !ok || math.Abs(score-previous) > scoreChange*previous {
+			return true
+		}
+	}
+	return false
+}
+
+// output pushes the map to the sink after every material change, once no
+// further change arrived for the debounce time. Empty maps are never pushed,
+// so a restart does not wipe the relays of consumers. A failed push is
+// retried with the next change.
+func (d *DerpererService) output(ctx context.Context, sink Sink) {
+	opts := sink.SinkOptions()
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	d.Registry.Subscribe(func(Event) { notify() })
+	// push endpoints loaded from storage without waiting for a change
+	notify()
+
+	var last *material
+	for {
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(opts.Debounce):
+		case <-ctx.Done():
+			return
+		}
+		// the map is built now, so it includes the changes of the debounce time
+		select {
+		case <-changed:
+		default:
+		}
+
+		pushed, err := d.push(ctx, sink, opts, last)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			outputErrors.WithLabelValues(sink.Name()).Inc()
+			d.Logger.Error("failed to push derp map", zap.String("sink", sink.Name()), zap.Error(err))
+			continue
+		}
+		last = pushed
+	}
+}
+
+// push builds the map of the sink and pushes it if it differs materially from
+// the last pushed map, it returns the material of the pushed map.
+func (d *DerpererService) push(ctx context.Context, sink Sink, opts SinkOptions, last *material) (*material, error) {
+	client, _ := netip.ParseAddr(opts.Query.Client)
+	m, err := d.DERPMap(d.Registry.Snapshot().Query(opts.Query), opts.Query, client)
+	if err != nil {
+		return last, err
+	}
+	if len(m.Regions) == 0 {
+		return last, nil
+	}
+	current := newMaterial(m, opts.Format == FormatJSON)
+	if !current.changed(last) {
+		return last, nil
+	}
+	data, contentType, err := RenderDERPMap(m, opts.Format)
+	if err != nil {
+		return last, err
+	}
+	if err := sink.Push(ctx, data, contentType); err != nil {
+		return last, err
+	}
+	outputPushes.WithLabelValues(sink.Name()).Inc()
+	d.Logger.Info("pushed derp map", zap.String("sink", sink.Name()), zap.Int("regions", len(m.Regions)))
+	return current, nil
+}
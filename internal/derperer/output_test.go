@@ -0,0 +1,99 @@
+package derperer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	opts   SinkOptions
+	pushes chan []byte
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) SinkOptions() SinkOptions {
+	return s.opts
+}
+
+func (s *fakeSink) Push(ctx context.Context, data []byte, contentType string) error {
+	s.pushes <- data
+	return nil
+}
+
+// expectPush waits for a push, or for none if want is false.
+func (s *fakeSink) expectPush(t *testing.T, want bool) *DERPMap {
+	t.Helper()
+	select {
+	case data := <-s.pushes:
+		if !want {
+			t.Fatalf("unexpected push %s", data)
+		}
+		var m DERPMap
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		return &m
+	case <-time.After(5 * s.opts.Debounce):
+		if want {
+			t.Fatal("no push")
+		}
+		return nil
+	}
+}
+
+func TestOutputDebouncesMaterialChanges(t *testing.T) {
+	d := newTestService()
+	d.Setup(context.Background())
+	sink := &fakeSink{
+		opts:   SinkOptions{Query: &DerpQueryParams{}, Format: FormatJSON, Debounce: 50 * time.Millisecond},
+		pushes: make(chan []byte, 10),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.output(ctx, sink)
+
+	// the empty map is not pushed
+	sink.expectPush(t, false)
+
+	// endpoints added within the debounce time are pushed at once
+	for _, host := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		d.Registry.Add(&DerpEndpoint{Host: host, Port: 443, Status: DerpStatusAvailable, Latency: 10 * time.Millisecond}, d.config.regionIDRange())
+	}
+	m := sink.expectPush(t, true)
+	if len(m.Regions) != 3 {
+		t.Errorf("pushed %d regions, want 3", len(m.Regions))
+	}
+	sink.expectPush(t, false)
+
+	// latency jitter is no material change
+	d.Registry.Update("192.0.2.1", 443, func(endpoint *DerpEndpoint) {
+		endpoint.Latency = 11 * time.Millisecond
+	})
+	sink.expectPush(t, false)
+
+	// a status change is
+	d.Registry.Update("192.0.2.2", 443, func(endpoint *DerpEndpoint) {
+		endpoint.Status = DerpStatusError
+	})
+	m = sink.expectPush(t, true)
+	found := false
+	for _, region := range m.Regions {
+		for _, node := range region.Nodes {
+			if node.HostName == "192.0.2.2" {
+				found = true
+				if node.Status != DerpStatusError {
+					t.Errorf("pushed status %s, want %s", node.Status, DerpStatusError)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("192.0.2.2 is missing from the pushed map")
+	}
+	sink.expectPush(t, false)
+}
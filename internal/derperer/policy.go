@@ -0,0 +1,83 @@
+package derperer
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+
+	"github.com/go-errors/errors"
+	"github.com/tailscale/hujson"
+	"tailscale.com/tailcfg"
+)
+
+// PolicyDERPMap is the derpMap block of a Tailscale policy file, which
+// takes no home params.
+type PolicyDERPMap struct {
+	OmitDefaultRegions bool                        `json:"OmitDefaultRegions,omitempty"`
+	Regions            map[int]*tailcfg.DERPRegion `json:"Regions"`
+}
+
+// Policy converts the map to the derpMap block of a Tailscale policy file,
+// with omitDefaultRegions clients only use its regions.
+func (m *DERPMap) Policy(omitDefaultRegions bool) *PolicyDERPMap {
+	p := &PolicyDERPMap{
+		OmitDefaultRegions: omitDefaultRegions,
+		Regions:            make(map[int]*tailcfg.DERPRegion, len(m.Regions)),
+	}
+	for id, region := range m.Regions {
+		p.Regions[id] = region.ToOriginal()
+	}
+	return p
+}
+
+// PolicyFragment renders the derpMap block as a policy file fragment.
+func PolicyFragment(derpMap *PolicyDERPMap) ([]byte, error) {
+	data, err := json.MarshalIndent(map[string]any{"derpMap": derpMap}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// MergePolicy sets the derpMap block of the HuJSON policy file, keeping the
+// comments and formatting of all other keys.
+func MergePolicy(policy []byte, derpMap *PolicyDERPMap) ([]byte, error) {
+	v, err := hujson.Parse(policy)
+	if err != nil {
+		return nil, errors.Errorf("parse policy: %w", err)
+	}
+	obj, ok := v.Value.(*hujson.Object)
+	if !ok {
+		return nil, errors.Errorf("policy is not an object")
+	}
+	exists := slices.ContainsFunc(obj.Members, isDERPMapMember)
+	value, err := json.MarshalIndent(derpMap, "\t", "\t")
+	if err != nil {
+		return nil, err
+	}
+	// built by hand, json.Marshal would compact the indented value
+	patch := append([]byte(`[{"op": "add", "path": "/derpMap", "value": `), value...)
+	patch = append(patch, "}]"...)
+	if err := v.Patch(patch); err != nil {
+		return nil, errors.Errorf("patch policy: %w", err)
+	}
+	if !exists {
+		// put a new block on a line of its own
+		member := &obj.Members[slices.IndexFunc(obj.Members, isDERPMapMember)]
+		// keep comments the patch moved in front of the block
+		if before := member.Name.BeforeExtra; len(before) == 0 || before[len(before)-1] != '\n' {
+			member.Name.BeforeExtra = append(before, '\n')
+		}
+		member.Name.BeforeExtra = append(member.Name.BeforeExtra, '\t')
+		member.Value.BeforeExtra = hujson.Extra(" ")
+		if !bytes.ContainsRune(obj.AfterExtra, '\n') {
+			obj.AfterExtra = append(obj.AfterExtra, '\n')
+		}
+	}
+	return v.Pack(), nil
+}
+
+func isDERPMapMember(m hujson.ObjectMember) bool {
+	name, ok := m.Name.Value.(hujson.Literal)
+	return ok && name.String() == "derpMap"
+}
@@ -0,0 +1,92 @@
+package derperer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/tailcfg"
+)
+
+func TestMergePolicy(t *testing.T) {
+	derpMap := &PolicyDERPMap{
+		OmitDefaultRegions: true,
+		Regions: map[int]*tailcfg.DERPRegion{900: {
+			RegionID:   900,
+			RegionCode: "tokyo",
+			Nodes:      []*tailcfg.DERPNode{{Name: "900a", RegionID: 900, HostName: "derp.example.com"}},
+		}},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		policy string
+		// keep are parts of the policy which must survive the merge
+		keep []string
+	}{
+		{
+			name: "added",
+			policy: `{
+	// access rules
+	"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],
+	"ssh": [], // no ssh
+}
+`,
+			keep: []string{"// access rules", `"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],`, "// no ssh"},
+		},
+		{
+			name: "replaced",
+			policy: `{
+	// groups
+	"groups": {"group:admin": ["alice@example.com"]},
+	"derpMap": {"Regions": {"1": {"RegionID": 1, "RegionCode": "old"}}},
+	/* trailing */
+	"tagOwners": {},
+}
+`,
+			keep: []string{"// groups", `"groups": {"group:admin": ["alice@example.com"]},`, "/* trailing */", `"tagOwners": {},`},
+		},
+		{
+			name:   "empty",
+			policy: "{}\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := MergePolicy([]byte(tt.policy), derpMap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, keep := range tt.keep {
+				if !strings.Contains(string(merged), keep) {
+					t.Errorf("%q is missing from the merged policy:\n%s", keep, merged)
+				}
+			}
+			if strings.Count(string(merged), `"derpMap"`) != 1 || !strings.Contains(string(merged), "\n\t\"derpMap\": {") {
+				t.Errorf("want exactly one derpMap on a line of its own:\n%s", merged)
+			}
+
+			std, err := hujson.Standardize(merged)
+			if err != nil {
+				t.Fatalf("merged policy is invalid: %v\n%s", err, merged)
+			}
+			var got struct {
+				DERPMap *PolicyDERPMap `json:"derpMap"`
+			}
+			if err := json.Unmarshal(std, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.DERPMap == nil || !got.DERPMap.OmitDefaultRegions || len(got.DERPMap.Regions) != 1 || got.DERPMap.Regions[900].RegionCode != "tokyo" {
+				t.Errorf("merged derpMap %+v, want the new one", got.DERPMap)
+			}
+		})
+	}
+}
+
+func TestMergePolicyRejectsInvalid(t *testing.T) {
+	for _, policy := range []string{"", "[]", `{"acls": [}`} {
+		if _, err := MergePolicy([]byte(policy), &PolicyDERPMap{}); err == nil {
+			t.Errorf("no error for policy %q", policy)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package derperer
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/go-errors/errors"
+)
+
+// stableRegionID derives the region ID of host:port from a hash of the
+// endpoint identity, so that every instance assigns the same ID to the same
+// server. On collision the next free ID in [min, max] is used.
+func stableRegionID(host string, port int, min, max int, used map[int]bool) (int, error) {
+	if min > max {
+		return 0, errors.Errorf("invalid region id range [%d, %d]", min, max)
+	}
+	size := uint32(max - min + 1)
+
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", host, port)
+	offset := h.Sum32() % size
+
+	for i := uint32(0); i < size; i++ {
+		id := min + int((offset+i)%size)
+		if !used[id] {
+			return id, nil
+		}
+	}
+	return 0, errors.Errorf("no free region id in [%d, %d]", min, max)
+}
+
+// regionID keeps the original region ID of an imported endpoint when it is
+// free, and derives a stable one otherwise.
+func regionID(endpoint *DerpEndpoint, ids IDRange, used map[int]bool) (int, error) {
+	if endpoint.OriginalID != 0 && !used[endpoint.OriginalID] {
+		return endpoint.OriginalID, nil
+	}
+	return stableRegionID(endpoint.Host, endpoint.Port, ids.Min, ids.Max, used)
+}
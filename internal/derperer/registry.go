@@ -0,0 +1,194 @@
+package derperer
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventUpdated EventType = "updated"
+	EventRemoved EventType = "removed"
+)
+
+// Event describes a change of the registry, Endpoint is the new state of the
+// endpoint, or the last state for EventRemoved.
+type Event struct {
+	Type     EventType
+	Endpoint *DerpEndpoint
+}
+
+// IDRange is the inclusive range of region IDs assigned by the registry.
+type IDRange struct {
+	Min int
+	Max int
+}
+
+// Registry owns the endpoint set. Writes are serialised and every write
+// publishes a new snapshot, endpoints in a snapshot are never modified so
+// readers don't need any locking.
+type Registry struct {
+	mu        sync.Mutex
+	endpoints map[string]*DerpEndpoint
+	listeners []func(Event)
+
+	snapshot atomic.Pointer[DerpEndpoints]
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		endpoints: map[string]*DerpEndpoint{},
+	}
+	r.snapshot.Store(&DerpEndpoints{})
+	return r
+}
+
+// Snapshot returns the current endpoints ordered by region ID, the returned
+// endpoints must not be modified.
+func (r *Registry) Snapshot() DerpEndpoints {
+	return *r.snapshot.Load()
+}
+
+// Subscribe registers fn to be called on every change. Listeners are called
+// in order while the registry is locked, so they must not write to it.
+func (r *Registry) Subscribe(fn func(Event)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+func (r *Registry) Get(host string, port int) (*DerpEndpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	endpoint, ok := r.endpoints[string(endpointKey(host, port))]
+	return endpoint, ok
+}
+
+// Load replaces the registry content with endpoints restored from storage and
+// reports every endpoint as added. Endpoints keep their stored region ID when
+// it is still valid, so that restarts publish the same IDs even when hashes
+// collide. The others are assigned a region ID in a fixed order, endpoints
+// keeping their original region ID first.
+func (r *Registry) Load(endpoints DerpEndpoints, ids IDRange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	slices.SortFunc(endpoints, func(a, b *DerpEndpoint) int {
+		return cmp.Or(
+			cmp.Compare(b.OriginalID, a.OriginalID),
+			strings.Compare(string(endpointKey(a.Host, a.Port)), string(endpointKey(b.Host, b.Port))),
+		)
+	})
+	r.endpoints = make(map[string]*DerpEndpoint, len(endpoints))
+	used := make(map[int]bool, len(endpoints))
+	var unassigned DerpEndpoints
+	for _, endpoint := range endpoints {
+		valid := endpoint.ID >= ids.Min && endpoint.ID <= ids.Max ||
+			endpoint.ID != 0 && endpoint.ID == endpoint.OriginalID
+		if !valid || used[endpoint.ID] {
+			unassigned = append(unassigned, endpoint)
+			continue
+		}
+		used[endpoint.ID] = true
+		r.endpoints[string(endpointKey(endpoint.Host, endpoint.Port))] = endpoint.clone()
+	}
+	for _, endpoint := range unassigned {
+		id, err := regionID(endpoint, ids, used)
+		if err != nil {
+			return err
+		}
+		used[id] = true
+		endpoint = endpoint.clone()
+		endpoint.ID = id
+		r.endpoints[string(endpointKey(endpoint.Host, endpoint.Port))] = endpoint
+	}
+	r.publish()
+	for _, endpoint := range r.Snapshot() {
+		r.notify(Event{Type: EventAdded, Endpoint: endpoint})
+	}
+	return nil
+}
+
+// Add inserts endpoint with its original region ID if it is free, or a region
+// ID from ids. If an endpoint with the same host and port exists, it is
+// returned instead and added is false.
+func (r *Registry) Add(endpoint *DerpEndpoint, ids IDRange) (_ *DerpEndpoint, added bool, _ error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := string(endpointKey(endpoint.Host, endpoint.Port))
+	if exist, ok := r.endpoints[key]; ok {
+		return exist, false, nil
+	}
+
+	used := make(map[int]bool, len(r.endpoints))
+	for _, e := range r.endpoints {
+		used[e.ID] = true
+	}
+	id, err := regionID(endpoint, ids, used)
+	if err != nil {
+		return nil, false, err
+	}
+
+	endpoint = endpoint.clone()
+	endpoint.ID = id
+	r.endpoints[key] = endpoint
+	r.publish()
+	r.notify(Event{Type: EventAdded, Endpoint: endpoint})
+	return endpoint, true, nil
+}
+
+// Update applies fn to a copy of the endpoint and publishes the copy.
+func (r *Registry) Update(host string, port int, fn func(*DerpEndpoint)) (*DerpEndpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := string(endpointKey(host, port))
+	exist, ok := r.endpoints[key]
+	if !ok {
+		return nil, false
+	}
+	endpoint := exist.clone()
+	fn(endpoint)
+	r.endpoints[key] = endpoint
+	r.publish()
+	r.notify(Event{Type: EventUpdated, Endpoint: endpoint})
+	return endpoint, true
+}
+
+func (r *Registry) Remove(host string, port int) (*DerpEndpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := string(endpointKey(host, port))
+	endpoint, ok := r.endpoints[key]
+	if !ok {
+		return nil, false
+	}
+	delete(r.endpoints, key)
+	r.publish()
+	r.notify(Event{Type: EventRemoved, Endpoint: endpoint})
+	return endpoint, true
+}
+
+func (r *Registry) publish() {
+	snapshot := make(DerpEndpoints, 0, len(r.endpoints))
+	for _, endpoint := range r.endpoints {
+		snapshot = append(snapshot, endpoint)
+	}
+	slices.SortFunc(snapshot, func(a, b *DerpEndpoint) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	r.snapshot.Store(&snapshot)
+}
+
+func (r *Registry) notify(event Event) {
+	for _, fn := range r.listeners {
+		fn(event)
+	}
+}
@@ -0,0 +1,157 @@
+package derperer
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// collidingHosts returns two hosts whose region IDs collide in ids, the
+// second one sorting before the first.
+func collidingHosts(t *testing.T, ids IDRange) (string, string) {
+	t.Helper()
+	seen := map[int]string{}
+	for i := 0; i < 1000; i++ {
+		host := fmt.Sprintf("h%03d", i)
+		id, err := stableRegionID(host, 443, ids.Min, ids.Max, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := seen[id]; ok {
+			return host, other
+		}
+		seen[id] = host
+	}
+	t.Fatal("no colliding hosts")
+	return "", ""
+}
+
+func TestRegistryLoadKeepsCollidingIDs(t *testing.T) {
+	ids := IDRange{Min: 900, Max: 909}
+	first, second := collidingHosts(t, ids)
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "derperer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	live := NewRegistry()
+	want := map[string]int{}
+	for _, host := range []string{first, second} {
+		endpoint, _, err := live.Add(&DerpEndpoint{Host: host, Port: 443}, ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Write([]Event{{Type: EventAdded, Endpoint: endpoint}}); err != nil {
+			t.Fatal(err)
+		}
+		want[host] = endpoint.ID
+	}
+	if want[first] == want[second] {
+		t.Fatalf("live run assigned %d twice", want[first])
+	}
+
+	endpoints, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewRegistry()
+	if err := restarted.Load(endpoints, ids); err != nil {
+		t.Fatal(err)
+	}
+	for host, id := range want {
+		endpoint, ok := restarted.Get(host, 443)
+		if !ok {
+			t.Fatalf("%s missing after restart", host)
+		}
+		if endpoint.ID != id {
+			t.Errorf("%s: region ID %d after restart, want %d", host, endpoint.ID, id)
+		}
+	}
+}
+
+func TestRegistryLoadReassignsInvalidIDs(t *testing.T) {
+	ids := IDRange{Min: 900, Max: 909}
+	registry := NewRegistry()
+	err := registry.Load(DerpEndpoints{
+		{Host: "a", Port: 443, ID: 905},
+		{Host: "b", Port: 443, ID: 905},
+		{Host: "c", Port: 443, ID: 1},
+		{Host: "d", Port: 443, ID: 2, OriginalID: 2},
+	}, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := map[int]string{}
+	for _, endpoint := range registry.Snapshot() {
+		if other, ok := used[endpoint.ID]; ok {
+			t.Errorf("%s and %s share region ID %d", endpoint.Host, other, endpoint.ID)
+		}
+		used[endpoint.ID] = endpoint.Host
+		inRange := endpoint.ID >= ids.Min && endpoint.ID <= ids.Max
+		if endpoint.Host == "d" && endpoint.ID != 2 || endpoint.Host != "d" && !inRange {
+			t.Errorf("%s: unexpected region ID %d", endpoint.Host, endpoint.ID)
+		}
+	}
+	if used[905] != "a" {
+		t.Errorf("region ID 905 went to %q, want a", used[905])
+	}
+}
+
+func TestRegistryConcurrentUpdateSnapshot(t *testing.T) {
+	ids := IDRange{Min: 900, Max: 999}
+	registry := NewRegistry()
+	var events atomic.Int64
+	registry.Subscribe(func(Event) { events.Add(1) })
+	for i := 0; i < 10; i++ {
+		if _, _, err := registry.Add(&DerpEndpoint{Host: fmt.Sprintf("h%d", i), Port: 443}, ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				registry.Update(host, 443, func(endpoint *DerpEndpoint) {
+					endpoint.Latency++
+					endpoint.Results = map[string]*CheckResult{host: {}}
+				})
+			}
+		}(fmt.Sprintf("h%d", i))
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				snapshot := registry.Snapshot()
+				if len(snapshot) != 10 {
+					t.Errorf("snapshot holds %d endpoints, want 10", len(snapshot))
+				}
+				for k, endpoint := range snapshot {
+					_ = endpoint.Latency
+					_ = len(endpoint.Results)
+					if k > 0 && snapshot[k-1].ID >= endpoint.ID {
+						t.Errorf("snapshot not ordered by region ID")
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, endpoint := range registry.Snapshot() {
+		if endpoint.Latency != 100 {
+			t.Errorf("%s: %d updates applied, want 100", endpoint.Host, endpoint.Latency)
+		}
+	}
+	if n := events.Load(); n != 10+10*100 {
+		t.Errorf("%d events, want %d", n, 10+10*100)
+	}
+}
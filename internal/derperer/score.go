@@ -0,0 +1,132 @@
+package derperer
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+const (
+	minScore = 0.1
+	maxScore = 10
+
+	// uptimeAlpha is the weight of the latest check in the uptime ratio.
+	uptimeAlpha = 0.1
+)
+
+// ScoreWeights are the exponents of the factors of a region score, 0
+// ignores a factor and 1 lets it scale the score linearly.
+type ScoreWeights struct {
+	Latency    float64 `mapstructure:"latency_weight"`
+	Bandwidth  float64 `mapstructure:"bandwidth_weight"`
+	Uptime     float64 `mapstructure:"uptime_weight"`
+	Jitter     float64 `mapstructure:"jitter_weight"`
+	Preference float64 `mapstructure:"preference_weight"`
+}
+
+// Scores are the region scores of endpoints.
+type Scores map[*DerpEndpoint]float64
+
+// Scorer computes the region scores clients multiply their measured latency
+// to a region with when picking their home region, so scores below 1 prefer
+// a region and scores above 1 avoid it.
+type Scorer struct {
+	weights     ScoreWeights
+	preferences map[string]float64
+}
+
+// NewScorer returns a scorer with the weights and preferences of the form
+// key=factor, where key is the host, region code or source of endpoints.
+func NewScorer(weights ScoreWeights, preferences []string) (*Scorer, error) {
+	s := &Scorer{weights: weights, preferences: map[string]float64{}}
+	for _, preference := range preferences {
+		key, value, ok := strings.Cut(preference, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("invalid preference %q, expected key=factor", preference)
+		}
+		factor, err := strconv.ParseFloat(value, 64)
+		if err != nil || factor <= 0 {
+			return nil, errors.Errorf("invalid preference factor %q, expected a positive number", value)
+		}
+		s.preferences[key] = factor
+	}
+	return s, nil
+}
+
+// Scores scores the available endpoints relative to the median of all
+// available endpoints, others are left to the clients' own measurements.
+// Unknown factors don't affect the score.
+func (s *Scorer) Scores(endpoints DerpEndpoints) Scores {
+	var available DerpEndpoints
+	var latencies, bandwidths, jitters []float64
+	for _, endpoint := range endpoints {
+		if endpoint.Status != DerpStatusAvailable {
+			continue
+		}
+		available = append(available, endpoint)
+		latencies = append(latencies, float64(endpoint.Latency))
+		bandwidths = append(bandwidths, endpoint.Bandwidth.Value)
+		jitters = append(jitters, float64(endpoint.LatencyStats.Jitter+time.Millisecond))
+	}
+	latency, bandwidth, jitter := median(latencies), median(bandwidths), median(jitters)
+
+	scores := Scores{}
+	for _, endpoint := range available {
+		var score float64
+		score += s.weights.Latency * ratio(float64(endpoint.Latency), latency)
+		score += s.weights.Bandwidth * ratio(bandwidth, endpoint.Bandwidth.Value)
+		score += s.weights.Uptime * ratio(1, endpoint.Uptime)
+		score += s.weights.Jitter * ratio(float64(endpoint.LatencyStats.Jitter+time.Millisecond), jitter)
+		score += s.weights.Preference * math.Log(s.preference(endpoint))
+		scores[endpoint] = clampScore(math.Exp(score))
+	}
+	return scores
+}
+
+// clampScore limits a score to [minScore, maxScore], rounded for display.
+func clampScore(score float64) float64 {
+	return math.Round(min(max(score, minScore), maxScore)*1000) / 1000
+}
+
+func (s *Scorer) preference(endpoint *DerpEndpoint) float64 {
+	for _, key := range []string{endpoint.Host, endpoint.Name, endpoint.Source} {
+		if factor, ok := s.preferences[key]; ok {
+			return factor
+		}
+	}
+	return 1
+}
+
+// ratio returns the log of a/b, or 0 if either is unknown.
+func ratio(a, b float64) float64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	return math.Log(a / b)
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	slices.Sort(values)
+	return values[len(values)/2]
+}
+
+// updateUptime folds a check into the uptime ratio of the endpoint, an
+// exponential moving average of its check results.
+func (d *DerpEndpoint) updateUptime(available bool) {
+	var value float64
+	if available {
+		value = 1
+	}
+	if d.CheckedAt.IsZero() {
+		d.Uptime = value
+		return
+	}
+	d.Uptime += uptimeAlpha * (value - d.Uptime)
+}
@@ -0,0 +1,138 @@
+package derperer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+)
+
+func TestScores(t *testing.T) {
+	weights := ScoreWeights{Latency: 1, Bandwidth: 0.5, Uptime: 1, Jitter: 0.5, Preference: 1}
+	endpoint := func(host string, latency time.Duration, bandwidth float64, uptime float64) *DerpEndpoint {
+		return &DerpEndpoint{
+			Host:      host,
+			Status:    DerpStatusAvailable,
+			Latency:   latency,
+			Bandwidth: speedtest.Unit{Value: bandwidth, Uint: "bps"},
+			Uptime:    uptime,
+		}
+	}
+	median := endpoint("median", 50*time.Millisecond, 100e6, 1)
+
+	for _, tt := range []struct {
+		name     string
+		endpoint *DerpEndpoint
+		// want is below 1, 1 or above 1
+		want int
+	}{
+		{"median", median, 0},
+		{"fast", endpoint("fast", 10*time.Millisecond, 100e6, 1), -1},
+		{"slow", endpoint("slow", 250*time.Millisecond, 100e6, 1), 1},
+		{"high bandwidth", endpoint("high bandwidth", 50*time.Millisecond, 400e6, 1), -1},
+		{"flaky", endpoint("flaky", 50*time.Millisecond, 100e6, 0.3), 1},
+		{"unknown uptime", endpoint("unknown uptime", 50*time.Millisecond, 100e6, 0), 0},
+		{"preferred", endpoint("preferred", 50*time.Millisecond, 100e6, 1), -1},
+		{"very slow", endpoint("very slow", 100*time.Second, 1, 0.1), 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScorer(weights, []string{"preferred=0.5"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the median of three is the median endpoint
+			endpoints := DerpEndpoints{median, endpoint("median 2", 50*time.Millisecond, 100e6, 1), tt.endpoint}
+			if tt.endpoint == median {
+				endpoints = endpoints[:2]
+			}
+			score, ok := s.Scores(endpoints)[tt.endpoint]
+			if !ok {
+				t.Fatal("not scored")
+			}
+			if score < minScore || score > maxScore {
+				t.Errorf("score %v outside [%v, %v]", score, minScore, maxScore)
+			}
+			switch {
+			case tt.want < 0 && score >= 1, tt.want == 0 && score != 1, tt.want > 0 && score <= 1:
+				t.Errorf("score %v, want %s", score, map[int]string{-1: "< 1", 0: "1", 1: "> 1"}[tt.want])
+			}
+		})
+	}
+}
+
+func TestScoresSkipUnavailable(t *testing.T) {
+	s, err := NewScorer(ScoreWeights{Latency: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &DerpEndpoint{Host: "down", Status: DerpStatusError}
+	if _, ok := s.Scores(DerpEndpoints{down})[down]; ok {
+		t.Error("unavailable endpoint scored")
+	}
+}
+
+func TestClampScore(t *testing.T) {
+	for _, tt := range []struct {
+		score, want float64
+	}{
+		{0, minScore},
+		{0.01, minScore},
+		{0.5, 0.5},
+		{1.23456, 1.235},
+		{100, maxScore},
+		{math.Inf(1), maxScore},
+	} {
+		if got := clampScore(tt.score); got != tt.want {
+			t.Errorf("clampScore(%v) = %v, want %v", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateUptime(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		checks []bool
+		min    float64
+		max    float64
+	}{
+		{"first available", []bool{true}, 1, 1},
+		{"first failed", []bool{false}, 0, 0},
+		{"one failure", []bool{true, false}, 0.9, 0.9},
+		{"recovering", []bool{false, true, true}, 0.19, 0.19},
+		{"flaky", []bool{true, false, true, false, true, false, true, false, true, false}, 0.5, 0.7},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var endpoint DerpEndpoint
+			for _, available := range tt.checks {
+				endpoint.updateUptime(available)
+				endpoint.CheckedAt = time.Now()
+				if endpoint.Uptime < 0 || endpoint.Uptime > 1 {
+					t.Fatalf("uptime %v outside [0, 1]", endpoint.Uptime)
+				}
+			}
+			if endpoint.Uptime < tt.min-1e-9 || endpoint.Uptime > tt.max+1e-9 {
+				t.Errorf("uptime %v, want within [%v, %v]", endpoint.Uptime, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestVantageIgnoresLocalUptime(t *testing.T) {
+	d := newTestService()
+	d.Registry.Add(&DerpEndpoint{Host: "192.0.2.1", Port: 443, Status: DerpStatusError, Uptime: 0.1}, d.config.regionIDRange())
+	d.Report(&AgentReport{Vantage: "tokyo", Results: []AgentResult{{
+		Host:   "192.0.2.1",
+		Port:   443,
+		Result: &CheckResult{Status: DerpStatusAvailable, Latency: 10 * time.Millisecond, CheckedAt: time.Now()},
+	}}})
+
+	s, err := NewScorer(ScoreWeights{Uptime: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := d.Registry.Snapshot().Vantage("tokyo")
+	if score := s.Scores(endpoints)[endpoints[0]]; score != 1 {
+		t.Errorf("score %v from the agent, want 1 without a known uptime", score)
+	}
+}
@@ -0,0 +1,99 @@
+package derperer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// Candidate is a possible DERP server reported by a DiscoverySource.
+type Candidate struct {
+	// Source is the name of the source which reported the candidate.
+	Source string
+
+	Host string
+	Port int
+	IP   net.IP
+
+	Country string
+	Region  string
+	City    string
+	Org     string
+	// ASN is the autonomous system number with its AS prefix, e.g. AS13335.
+	ASN string
+
+	// Pinned endpoints are never evicted.
+	Pinned bool
+	// Insecure skips certificate verification of hostnames, endpoints
+	// reported by IP are always insecure.
+	Insecure bool
+
+	// RegionID, RegionCode and NodeName are kept from an imported DERP map
+	// or peer, RegionID is used if it is not taken by another endpoint.
+	RegionID   int
+	RegionCode string
+	NodeName   string
+
+	// Peer is the federation peer which reported the candidate along with
+	// its check Result, if any.
+	Peer   string
+	Result *CheckResult
+}
+
+// Code builds the human readable region code of the candidate from its
+// location, organization and IP, or its host when none of them is known.
+// An imported region code is used as is.
+func (c *Candidate) Code() string {
+	if c.RegionCode != "" {
+		return c.RegionCode
+	}
+	var parts []string
+	for _, part := range []string{c.Country, c.Region, c.City, c.Org} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if c.IP != nil {
+		parts = append(parts, c.IP.String())
+	}
+	if len(parts) == 0 {
+		return c.Host
+	}
+	return strings.Join(parts, "-")
+}
+
+type FetchOptions struct {
+	// Limit is the maximum number of candidates the source should return.
+	Limit int
+	// ChinaOnly asks the source to only return candidates located in China.
+	ChinaOnly bool
+}
+
+// DiscoverySource finds candidate DERP servers.
+type DiscoverySource interface {
+	Name() string
+	Fetch(ctx context.Context, opts FetchOptions) ([]*Candidate, error)
+}
+
+// SourceOptions overrides the service wide refetch settings for a source,
+// zero values fall back to the service configuration.
+type SourceOptions struct {
+	Interval time.Duration
+	Limit    int
+}
+
+// ScheduledSource is implemented by sources with their own refetch settings.
+type ScheduledSource interface {
+	DiscoverySource
+	SourceOptions() SourceOptions
+}
+
+// WatchedSource is implemented by sources which know when their candidates
+// change. A value on the channel triggers a fetch before the next interval,
+// and watched sources are always fetched on startup.
+type WatchedSource interface {
+	DiscoverySource
+	// Changes is called once per Run, it may return nil.
+	Changes(ctx context.Context) <-chan struct{}
+}
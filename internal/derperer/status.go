@@ -0,0 +1,120 @@
+package derperer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type SourceStatus struct {
+	Name        string    `json:"name"`
+	LastFetch   time.Time `json:"last_fetch,omitzero"`
+	Candidates  int       `json:"candidates"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+	Errors      int       `json:"errors"`
+}
+
+type ServiceStatus struct {
+	Ready       bool   `json:"ready"`
+	ReadyReason string `json:"ready_reason,omitempty"`
+
+	Sources []SourceStatus `json:"sources"`
+
+	LastRecheck         time.Time `json:"last_recheck,omitzero"`
+	LastRecheckDuration string    `json:"last_recheck_duration,omitempty"`
+	RecheckCycles       int       `json:"recheck_cycles"`
+
+	Endpoints      int                `json:"endpoints"`
+	EndpointStatus map[DerpStatus]int `json:"endpoint_status"`
+}
+
+type status struct {
+	mu sync.Mutex
+
+	sources map[string]*SourceStatus
+
+	lastRecheck         time.Time
+	lastRecheckDuration time.Duration
+	recheckCycles       int
+}
+
+func (s *status) source(name string) *SourceStatus {
+	if s.sources == nil {
+		s.sources = map[string]*SourceStatus{}
+	}
+	if _, ok := s.sources[name]; !ok {
+		s.sources[name] = &SourceStatus{Name: name}
+	}
+	return s.sources[name]
+}
+
+func (s *status) fetched(name string, at time.Time, candidates int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	source := s.source(name)
+	source.LastFetch = at
+	source.Candidates = candidates
+	if err != nil {
+		source.LastError = err.Error()
+		source.LastErrorAt = at
+		source.Errors++
+	}
+}
+
+func (s *status) rechecked(at time.Time, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRecheck = at
+	s.lastRecheckDuration = duration
+	s.recheckCycles++
+}
+
+// Ready reports whether every source finished a discovery, at least one
+// recheck cycle finished and enough endpoints are available.
+func (d *DerpererService) Ready() (bool, string) {
+	d.status.mu.Lock()
+	for _, source := range d.sources {
+		if s, ok := d.status.sources[source.Name()]; !ok || s.LastFetch.IsZero() {
+			d.status.mu.Unlock()
+			return false, fmt.Sprintf("source %s has not finished discovery", source.Name())
+		}
+	}
+	cycles := d.status.recheckCycles
+	d.status.mu.Unlock()
+
+	if cycles == 0 {
+		return false, "no recheck cycle finished"
+	}
+	available := len(d.Registry.Snapshot().Query(&DerpQueryParams{Status: DerpStatusAvailable}))
+	if available < d.config.ReadyMinAvailable {
+		return false, fmt.Sprintf("%d available endpoints, want at least %d", available, d.config.ReadyMinAvailable)
+	}
+	return true, ""
+}
+
+func (d *DerpererService) Status() *ServiceStatus {
+	res := &ServiceStatus{
+		Sources:        []SourceStatus{},
+		EndpointStatus: map[DerpStatus]int{},
+	}
+	res.Ready, res.ReadyReason = d.Ready()
+
+	d.status.mu.Lock()
+	for _, source := range d.sources {
+		res.Sources = append(res.Sources, *d.status.source(source.Name()))
+	}
+	res.LastRecheck = d.status.lastRecheck
+	if d.status.recheckCycles > 0 {
+		res.LastRecheckDuration = d.status.lastRecheckDuration.String()
+	}
+	res.RecheckCycles = d.status.recheckCycles
+	d.status.mu.Unlock()
+
+	endpoints := d.Registry.Snapshot()
+	res.Endpoints = len(endpoints)
+	for _, endpoint := range endpoints {
+		res.EndpointStatus[endpoint.Status]++
+	}
+	return res
+}
@@ -0,0 +1,67 @@
+package derperer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	d := newTestService()
+	d.config.ReadyMinAvailable = 2
+	static := &fakeSource{name: "static", candidates: []*Candidate{
+		{Host: "192.0.2.1", Port: 443, Pinned: true},
+		{Host: "192.0.2.2", Port: 443, Pinned: true},
+	}}
+	d.AddSource(static)
+
+	expect := func(want bool, reason string) {
+		t.Helper()
+		ready, got := d.Ready()
+		if ready != want || got != reason {
+			t.Errorf("ready %t (%q), want %t (%q)", ready, got, want, reason)
+		}
+	}
+	expect(false, "source static has not finished discovery")
+
+	d.fetch(context.Background(), static, SourceOptions{})
+	expect(false, "no recheck cycle finished")
+
+	d.status.rechecked(time.Now(), time.Second)
+	expect(false, "0 available endpoints, want at least 2")
+
+	for _, host := range []string{"192.0.2.1", "192.0.2.2"} {
+		d.Registry.Update(host, 443, func(endpoint *DerpEndpoint) {
+			endpoint.Status = DerpStatusAvailable
+		})
+	}
+	expect(true, "")
+}
+
+func TestStatusReportsSourceErrors(t *testing.T) {
+	d := newTestService()
+	scan := &fakeSource{name: "scan", candidates: []*Candidate{{Host: "192.0.2.1", Port: 443}}}
+	d.AddSource(scan)
+	d.fetch(context.Background(), scan, SourceOptions{})
+
+	scan.candidates, scan.err = nil, errors.New("quota exceeded")
+	d.fetch(context.Background(), scan, SourceOptions{})
+	d.fetch(context.Background(), scan, SourceOptions{})
+
+	status := d.Status()
+	if len(status.Sources) != 1 {
+		t.Fatalf("%d sources, want 1", len(status.Sources))
+	}
+	source := status.Sources[0]
+	if source.Name != "scan" || source.Errors != 2 || source.LastError != "quota exceeded" || source.LastErrorAt.IsZero() {
+		t.Errorf("source status %+v, want 2 errors, the last one quota exceeded", source)
+	}
+	// the endpoint found before is kept
+	if status.Endpoints != 1 || status.EndpointStatus[DerpStatusUnknown] != 1 {
+		t.Errorf("%d endpoints %v, want 1 unknown", status.Endpoints, status.EndpointStatus)
+	}
+	if status.Ready || status.ReadyReason != "no recheck cycle finished" {
+		t.Errorf("ready %t (%q), want not ready without a recheck", status.Ready, status.ReadyReason)
+	}
+}
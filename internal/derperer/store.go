@@ -0,0 +1,125 @@
+package derperer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-errors/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	endpointsBucket = []byte("endpoints")
+	fetchesBucket   = []byte("fetches")
+)
+
+// Store persists endpoints, their region IDs and check results across restarts.
+type Store interface {
+	Load() (DerpEndpoints, error)
+	// Write saves the endpoints of the events in one transaction, and
+	// deletes those of EventRemoved.
+	Write(events []Event) error
+	// LastFetch returns when the source was last fetched, zero if never.
+	LastFetch(source string) (time.Time, error)
+	SaveLastFetch(source string, t time.Time) error
+	Close() error
+}
+
+var _ Store = (*boltStore)(nil)
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func OpenStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Errorf("create storage directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Errorf("open storage %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{endpointsBucket, fetchesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, errors.Errorf("create buckets: %w", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func endpointKey(host string, port int) []byte {
+	return []byte(fmt.Sprintf("%s:%d", host, port))
+}
+
+func (s *boltStore) Load() (DerpEndpoints, error) {
+	var endpoints DerpEndpoints
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(endpointsBucket).ForEach(func(k, v []byte) error {
+			endpoint := &DerpEndpoint{}
+			if err := json.Unmarshal(v, endpoint); err != nil {
+				return errors.Errorf("decode endpoint %s: %w", k, err)
+			}
+			endpoints = append(endpoints, endpoint)
+			return nil
+		})
+	})
+	return endpoints, err
+}
+
+func (s *boltStore) Write(events []Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(endpointsBucket)
+		for _, event := range events {
+			key := endpointKey(event.Endpoint.Host, event.Endpoint.Port)
+			if event.Type == EventRemoved {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+				continue
+			}
+			b, err := json.Marshal(event.Endpoint)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) LastFetch(source string) (time.Time, error) {
+	var t time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(fetchesBucket).Get([]byte(source))
+		if v == nil {
+			return nil
+		}
+		return t.UnmarshalBinary(v)
+	})
+	return t, err
+}
+
+func (s *boltStore) SaveLastFetch(source string, t time.Time) error {
+	b, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(fetchesBucket).Put([]byte(source), b)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,114 @@
+package derperer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "derperer.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &DerpEndpoint{ID: 901, Host: "192.0.2.1", Port: 443, Status: DerpStatusAvailable, Latency: 10 * time.Millisecond}
+	b := &DerpEndpoint{ID: 902, Host: "192.0.2.2", Port: 443}
+	if err := store.Write([]Event{{Type: EventAdded, Endpoint: a}, {Type: EventAdded, Endpoint: b}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write([]Event{{Type: EventRemoved, Endpoint: b}}); err != nil {
+		t.Fatal(err)
+	}
+	fetched := time.Now().Round(0)
+	if err := store.SaveLastFetch("static", fetched); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	endpoints, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Host != a.Host || endpoints[0].ID != a.ID || endpoints[0].Latency != a.Latency {
+		t.Errorf("loaded %+v, want only %+v", endpoints, a)
+	}
+	if last, err := store.LastFetch("static"); err != nil || !last.Equal(fetched) {
+		t.Errorf("last fetch %s (%v), want %s", last, err, fetched)
+	}
+	if last, err := store.LastFetch("scan"); err != nil || !last.IsZero() {
+		t.Errorf("last fetch of an unfetched source %s (%v), want zero", last, err)
+	}
+}
+
+func TestPersistAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "derperer.db")
+	start := func() *DerpererService {
+		d := newTestService()
+		d.config.Storage = path
+		d.Setup(context.Background())
+		return d
+	}
+
+	d := start()
+	want := map[string]int{}
+	for _, host := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		endpoint, _, err := d.Registry.Add(&DerpEndpoint{Host: host, Port: 443}, d.config.regionIDRange())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[host] = endpoint.ID
+	}
+	// written by the loop while the service runs
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.persistLoop(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		endpoints, err := d.store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(endpoints) == len(want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d endpoints persisted, want %d", len(endpoints), len(want))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// changes after the loop stopped are written on close
+	d.Registry.Update("192.0.2.1", 443, func(endpoint *DerpEndpoint) {
+		endpoint.Status = DerpStatusAvailable
+	})
+	d.Registry.Remove("192.0.2.3", 443)
+	delete(want, "192.0.2.3")
+	d.Close(context.Background())
+
+	d = start()
+	defer d.Close(context.Background())
+	endpoints := d.Registry.Snapshot()
+	if len(endpoints) != len(want) {
+		t.Fatalf("%d endpoints after restart, want %d", len(endpoints), len(want))
+	}
+	for _, endpoint := range endpoints {
+		if id, ok := want[endpoint.Host]; !ok || endpoint.ID != id {
+			t.Errorf("%s: region ID %d after restart, want %d", endpoint.Host, endpoint.ID, id)
+		}
+		if endpoint.Host == "192.0.2.1" && endpoint.Status != DerpStatusAvailable {
+			t.Errorf("%s: status %s after restart, want %s", endpoint.Host, endpoint.Status, DerpStatusAvailable)
+		}
+	}
+}
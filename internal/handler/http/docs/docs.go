@@ -24,6 +24,29 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/agent/endpoints": {
+            "get": {
+                "description": "Endpoints for agents to check, requires the agent token as bearer token",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Agent endpoints",
+                "responses": {}
+            }
+        },
+        "/agent/results": {
+            "post": {
+                "description": "Report check results of an agent, requires the agent token as bearer token",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Agent results",
+                "responses": {}
+            }
+        },
         "/derp.json": {
             "get": {
                 "produces": [
@@ -53,10 +76,362 @@ const docTemplate = `{
                         "description": "bandwidth limit, e.g. 2Mbps",
                         "name": "bandwidth-limit",
                         "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "dial",
+                            "tls",
+                            "handshake",
+                            "timeout",
+                            "protocol",
+                            "short_read",
+                            "unknown"
+                        ],
+                        "type": "string",
+                        "description": "error class of failed endpoints",
+                        "name": "error-class",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "use the results of an agent by name, or of a federation peer as peer:name",
+                        "name": "vantage",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "none",
+                            "country",
+                            "city",
+                            "asn",
+                            "rule"
+                        ],
+                        "type": "string",
+                        "description": "group endpoints into regions, defaults to derperer.group_by",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "serve the endpoints nearest to the client, defaults to derperer.geoip.nearest",
+                        "name": "nearest",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "locate this IP instead of the requester",
+                        "name": "client",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/derp.yaml": {
+            "get": {
+                "description": "The DERP map in the YAML format of Headscale's derp.urls, takes the query parameters of /derp.json",
+                "produces": [
+                    "application/yaml"
+                ],
+                "summary": "Get DERP Map for Headscale",
+                "parameters": [
+                    {
+                        "enum": [
+                            "alive",
+                            "error",
+                            "all"
+                        ],
+                        "type": "string",
+                        "description": "alive|error|all",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "latency limit, e.g. 500ms",
+                        "name": "latency-limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "bandwidth limit, e.g. 2Mbps",
+                        "name": "bandwidth-limit",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "dial",
+                            "tls",
+                            "handshake",
+                            "timeout",
+                            "protocol",
+                            "short_read",
+                            "unknown"
+                        ],
+                        "type": "string",
+                        "description": "error class of failed endpoints",
+                        "name": "error-class",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "use the results of an agent by name, or of a federation peer as peer:name",
+                        "name": "vantage",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "none",
+                            "country",
+                            "city",
+                            "asn",
+                            "rule"
+                        ],
+                        "type": "string",
+                        "description": "group endpoints into regions, defaults to derperer.group_by",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "serve the endpoints nearest to the client, defaults to derperer.geoip.nearest",
+                        "name": "nearest",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "locate this IP instead of the requester",
+                        "name": "client",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/federation/export": {
+            "get": {
+                "description": "Endpoints with their local check results for federation peers, requires the federation token as bearer token",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Federation export",
+                "responses": {}
+            }
+        },
+        "/policy.hujson": {
+            "get": {
+                "description": "The derpMap block of a Tailscale policy file, takes the query parameters of /derp.json",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get DERP Map Policy Fragment",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "only use these regions instead of Tailscale's",
+                        "name": "omit-default-regions",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "alive",
+                            "error",
+                            "all"
+                        ],
+                        "type": "string",
+                        "description": "alive|error|all",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "latency limit, e.g. 500ms",
+                        "name": "latency-limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "bandwidth limit, e.g. 2Mbps",
+                        "name": "bandwidth-limit",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "dial",
+                            "tls",
+                            "handshake",
+                            "timeout",
+                            "protocol",
+                            "short_read",
+                            "unknown"
+                        ],
+                        "type": "string",
+                        "description": "error class of failed endpoints",
+                        "name": "error-class",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "use the results of an agent by name, or of a federation peer as peer:name",
+                        "name": "vantage",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "none",
+                            "country",
+                            "city",
+                            "asn",
+                            "rule"
+                        ],
+                        "type": "string",
+                        "description": "group endpoints into regions, defaults to derperer.group_by",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "serve the endpoints nearest to the client, defaults to derperer.geoip.nearest",
+                        "name": "nearest",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "locate this IP instead of the requester",
+                        "name": "client",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            },
+            "post": {
+                "description": "Sets the derpMap block of the posted HuJSON policy file and keeps all other keys, takes the query parameters of /policy.hujson",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Merge DERP Map into Policy",
+                "parameters": [
+                    {
+                        "description": "Tailscale policy file",
+                        "name": "policy",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "only use these regions instead of Tailscale's",
+                        "name": "omit-default-regions",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "alive",
+                            "error",
+                            "all"
+                        ],
+                        "type": "string",
+                        "description": "alive|error|all",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "none",
+                            "country",
+                            "city",
+                            "asn",
+                            "rule"
+                        ],
+                        "type": "string",
+                        "description": "group endpoints into regions, defaults to derperer.group_by",
+                        "name": "group",
+                        "in": "query"
                     }
                 ],
                 "responses": {}
             }
+        },
+        "/status": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Service status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/derperer.ServiceStatus"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "derperer.ServiceStatus": {
+            "type": "object",
+            "properties": {
+                "endpoint_status": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "endpoints": {
+                    "type": "integer"
+                },
+                "last_recheck": {
+                    "type": "string"
+                },
+                "last_recheck_duration": {
+                    "type": "string"
+                },
+                "ready": {
+                    "type": "boolean"
+                },
+                "ready_reason": {
+                    "type": "string"
+                },
+                "recheck_cycles": {
+                    "type": "integer"
+                },
+                "sources": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/derperer.SourceStatus"
+                    }
+                }
+            }
+        },
+        "derperer.SourceStatus": {
+            "type": "object",
+            "properties": {
+                "candidates": {
+                    "type": "integer"
+                },
+                "errors": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_error_at": {
+                    "type": "string"
+                },
+                "last_fetch": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -1,12 +1,18 @@
 package http
 
 import (
+	"cmp"
 	"context"
+	"io"
+	"net/netip"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/yoshino-s/derperer/internal/derperer"
 	"github.com/yoshino-s/go-framework/application"
 	"github.com/yoshino-s/go-framework/handlers/http"
+	"gopkg.in/yaml.v3"
 
 	_ "embed"
 
@@ -31,15 +37,52 @@ func New() *Handler {
 
 func (h *Handler) Setup(ctx context.Context) {
 	h.Handler.Setup(ctx)
+	// /-/readyz follows the service, see updateReady
+	h.Ready.Store(false)
+	if h.IPExtractor == nil {
+		// not behind a proxy, X-Forwarded-For and X-Real-IP are set by the
+		// client and can't be trusted for the nearest endpoints
+		h.IPExtractor = echo.ExtractIPDirect()
+	}
 	h.GET("/", echo.HandlerFunc(h.index))
 	h.GET("/derp.json", echo.HandlerFunc(h.getDerp))
+	h.GET("/derp.yaml", echo.HandlerFunc(h.getDerpYAML))
+	h.GET("/policy.hujson", echo.HandlerFunc(h.getPolicy))
+	h.POST("/policy.hujson", echo.HandlerFunc(h.mergePolicy))
+	h.GET("/status", echo.HandlerFunc(h.status))
+	h.GET("/federation/export", echo.HandlerFunc(h.federationExport))
+	h.GET("/agent/endpoints", echo.HandlerFunc(h.agentEndpoints))
+	h.POST("/agent/results", echo.HandlerFunc(h.agentResults))
 	h.GET("/swagger/*", echoSwagger.WrapHandler)
 }
 
 func (h *Handler) Run(ctx context.Context) {
+	go func() {
+		h.updateReady()
+		ticker := time.NewTicker(readyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.updateReady()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 	h.Handler.Run(ctx)
 }
 
+// readyInterval is how often the readiness of the service is polled.
+const readyInterval = time.Second
+
+// updateReady sets the readiness served at /-/readyz, see
+// DerpererService.Ready.
+func (h *Handler) updateReady() {
+	ready, _ := h.Derperer.Ready()
+	h.Ready.Store(ready)
+}
+
 //go:embed index.html
 var indexHTMLContent string
 
@@ -54,6 +97,11 @@ func (h *Handler) index(c echo.Context) error {
 // @Param status query string false "alive|error|all" Enums(alive, error, all)
 // @Param latency-limit query string false "latency limit, e.g. 500ms"
 // @Param bandwidth-limit query string string "bandwidth limit, e.g. 2Mbps"
+// @Param error-class query string false "error class of failed endpoints" Enums(dial, tls, handshake, timeout, protocol, short_read, unknown)
+// @Param vantage query string false "use the results of an agent by name, or of a federation peer as peer:name"
+// @Param group query string false "group endpoints into regions, defaults to derperer.group_by" Enums(none, country, city, asn, rule)
+// @Param nearest query int false "serve the endpoints nearest to the client, defaults to derperer.geoip.nearest"
+// @Param client query string false "locate this IP instead of the requester"
 // @Produce json
 // @Router /derp.json [get]
 func (h *Handler) getDerp(c echo.Context) error {
@@ -66,11 +114,157 @@ func (h *Handler) getDerp(c echo.Context) error {
 	// 	return err
 	// }
 	// return c.JSON(m)
+	m, err := h.derpMap(c)
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+
+	return c.JSON(200, m)
+}
+
+// @Summary Get DERP Map for Headscale
+// @Description The DERP map in the YAML format of Headscale's derp.urls, takes the query parameters of /derp.json
+// @Param status query string false "alive|error|all" Enums(alive, error, all)
+// @Param latency-limit query string false "latency limit, e.g. 500ms"
+// @Param bandwidth-limit query string string "bandwidth limit, e.g. 2Mbps"
+// @Param error-class query string false "error class of failed endpoints" Enums(dial, tls, handshake, timeout, protocol, short_read, unknown)
+// @Param vantage query string false "use the results of an agent by name, or of a federation peer as peer:name"
+// @Param group query string false "group endpoints into regions, defaults to derperer.group_by" Enums(none, country, city, asn, rule)
+// @Param nearest query int false "serve the endpoints nearest to the client, defaults to derperer.geoip.nearest"
+// @Param client query string false "locate this IP instead of the requester"
+// @Produce application/yaml
+// @Router /derp.yaml [get]
+func (h *Handler) getDerpYAML(c echo.Context) error {
+	m, err := h.derpMap(c)
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	data, err := yaml.Marshal(m.Headscale())
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(200, "application/yaml", data)
+}
+
+// @Summary Get DERP Map Policy Fragment
+// @Description The derpMap block of a Tailscale policy file, takes the query parameters of /derp.json
+// @Param omit-default-regions query bool false "only use these regions instead of Tailscale's"
+// @Param status query string false "alive|error|all" Enums(alive, error, all)
+// @Param latency-limit query string false "latency limit, e.g. 500ms"
+// @Param bandwidth-limit query string string "bandwidth limit, e.g. 2Mbps"
+// @Param error-class query string false "error class of failed endpoints" Enums(dial, tls, handshake, timeout, protocol, short_read, unknown)
+// @Param vantage query string false "use the results of an agent by name, or of a federation peer as peer:name"
+// @Param group query string false "group endpoints into regions, defaults to derperer.group_by" Enums(none, country, city, asn, rule)
+// @Param nearest query int false "serve the endpoints nearest to the client, defaults to derperer.geoip.nearest"
+// @Param client query string false "locate this IP instead of the requester"
+// @Produce json
+// @Router /policy.hujson [get]
+func (h *Handler) getPolicy(c echo.Context) error {
+	m, err := h.derpMap(c)
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	omit, _ := strconv.ParseBool(c.QueryParam("omit-default-regions"))
+	data, err := derperer.PolicyFragment(m.Policy(omit))
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(200, "application/hujson", data)
+}
+
+// @Summary Merge DERP Map into Policy
+// @Description Sets the derpMap block of the posted HuJSON policy file and keeps all other keys, takes the query parameters of /policy.hujson
+// @Accept json
+// @Param policy body string true "Tailscale policy file"
+// @Param omit-default-regions query bool false "only use these regions instead of Tailscale's"
+// @Param status query string false "alive|error|all" Enums(alive, error, all)
+// @Param group query string false "group endpoints into regions, defaults to derperer.group_by" Enums(none, country, city, asn, rule)
+// @Produce json
+// @Router /policy.hujson [post]
+func (h *Handler) mergePolicy(c echo.Context) error {
+	policy, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	m, err := h.derpMap(c)
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	omit, _ := strconv.ParseBool(c.QueryParam("omit-default-regions"))
+	data, err := derperer.MergePolicy(policy, m.Policy(omit))
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+
+	return c.Blob(200, "application/hujson", data)
+}
+
+func (h *Handler) derpMap(c echo.Context) (*derperer.DERPMap, error) {
 	var query derperer.DerpQueryParams
 
-	c.Bind(&query)
+	// query parameters only, the body of a POST is not a query
+	(&echo.DefaultBinder{}).BindQueryParams(c, &query)
 
-	m := h.Derperer.DerpEndpoints.Query(&query).Convert()
+	client, _ := netip.ParseAddr(cmp.Or(query.Client, c.RealIP()))
+	return h.Derperer.DERPMap(h.Derperer.Registry.Snapshot().Query(&query), &query, client)
+}
 
-	return c.JSON(200, m)
+// @Summary Service status
+// @Produce json
+// @Success 200 {object} derperer.ServiceStatus
+// @Router /status [get]
+func (h *Handler) status(c echo.Context) error {
+	return c.JSON(200, h.Derperer.Status())
+}
+
+// @Summary Federation export
+// @Description Endpoints with their local check results for federation peers, requires the federation token as bearer token
+// @Produce json
+// @Router /federation/export [get]
+func (h *Handler) federationExport(c echo.Context) error {
+	if !h.Derperer.FederationEnabled() {
+		return c.String(404, "federation export is disabled")
+	}
+	if !h.Derperer.AuthorizeFederation(c.Request().Header.Get("Authorization")) {
+		return c.String(401, "unauthorized")
+	}
+	return c.JSON(200, h.Derperer.Export())
+}
+
+// @Summary Agent endpoints
+// @Description Endpoints for agents to check, requires the agent token as bearer token
+// @Produce json
+// @Router /agent/endpoints [get]
+func (h *Handler) agentEndpoints(c echo.Context) error {
+	if !h.Derperer.AgentsEnabled() {
+		return c.String(404, "agents are disabled")
+	}
+	if !h.Derperer.AuthorizeAgent(c.Request().Header.Get("Authorization")) {
+		return c.String(401, "unauthorized")
+	}
+	return c.JSON(200, h.Derperer.Export())
+}
+
+// @Summary Agent results
+// @Description Report check results of an agent, requires the agent token as bearer token
+// @Accept json
+// @Produce plain
+// @Router /agent/results [post]
+func (h *Handler) agentResults(c echo.Context) error {
+	if !h.Derperer.AgentsEnabled() {
+		return c.String(404, "agents are disabled")
+	}
+	if !h.Derperer.AuthorizeAgent(c.Request().Header.Get("Authorization")) {
+		return c.String(401, "unauthorized")
+	}
+	var report derperer.AgentReport
+	if err := c.Bind(&report); err != nil {
+		return err
+	}
+	if report.Vantage == "" {
+		return c.String(400, "vantage is required")
+	}
+	return c.String(200, strconv.Itoa(h.Derperer.Report(&report)))
 }
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/configuration"
+)
+
+func TestRealIPIgnoresHeadersWithoutProxy(t *testing.T) {
+	h := New()
+	h.Setup(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/derp.json", nil)
+	req.RemoteAddr = "192.0.2.1:12345"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Real-IP", "198.51.100.2")
+	if ip := h.NewContext(req, httptest.NewRecorder()).RealIP(); ip != "192.0.2.1" {
+		t.Errorf("client IP %s, want the remote address 192.0.2.1", ip)
+	}
+}
+
+// readConfig reads the defaults of c and the values set in viper.
+func readConfig(c configuration.Configuration) {
+	c.Register(pflag.NewFlagSet("test", pflag.ContinueOnError))
+	c.Read()
+}
+
+func TestReadyzFollowsService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := New()
+	h.Derperer = derperer.New()
+	viper.Set("derperer.ready_min_available", 0)
+	readConfig(h.Configuration())
+	readConfig(h.Derperer.Configuration())
+	h.Derperer.Setup(ctx)
+	h.Setup(ctx)
+
+	readyz := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/readyz", nil))
+		return rec.Code
+	}
+	// the framework alone would report ready once set up
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Errorf("/-/readyz returned %d before the first recheck, want 503", code)
+	}
+
+	// without sources the service is ready after the first recheck
+	go h.Derperer.Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.updateReady()
+		if code := readyz(); code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("/-/readyz didn't turn ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsServesServiceMetrics(t *testing.T) {
+	h := New()
+	h.Derperer = derperer.New()
+	readConfig(h.Configuration())
+	h.Setup(context.Background())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "derperer_recheck_duration_seconds") {
+		t.Errorf("/-/metrics returned %d without the service metrics:\n%s", rec.Code, rec.Body)
+	}
+}
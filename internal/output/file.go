@@ -0,0 +1,99 @@
+package output
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+var _ derperer.Sink = (*FileSink)(nil)
+var _ derperer.Sink = (*HeadscaleSink)(nil)
+var _ configuration.Configuration = (*fileConfig)(nil)
+
+type fileConfig struct {
+	Config `mapstructure:",squash"`
+
+	Path string `mapstructure:"path"`
+
+	name   string
+	format string
+	query  string
+}
+
+func (c *fileConfig) Register(set *pflag.FlagSet) {
+	c.register(set, c.name, c.format, c.query)
+	set.String(fmt.Sprintf("output.%s.path", c.name), "", fmt.Sprintf("Path of the file %s output writes to", c.name))
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *fileConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings(c.name), c)
+}
+
+// FileSink writes the DERP map to a local file, replacing it atomically.
+type FileSink struct {
+	*application.EmptyApplication
+	config fileConfig
+
+	opts derperer.SinkOptions
+}
+
+func NewFile() *FileSink {
+	return newFileSink("file", "FileSink", derperer.FormatJSON, "")
+}
+
+// HeadscaleSink is a file sink for Headscale's derp.paths, which Headscale
+// reloads every derp.update_frequency.
+type HeadscaleSink struct {
+	*FileSink
+}
+
+func NewHeadscale() *HeadscaleSink {
+	return &HeadscaleSink{newFileSink("headscale", "HeadscaleSink", derperer.FormatYAML, "status=available")}
+}
+
+func newFileSink(name string, app string, format string, query string) *FileSink {
+	return &FileSink{
+		EmptyApplication: application.NewEmptyApplication(app),
+		config:           fileConfig{name: name, format: format, query: query},
+	}
+}
+
+func (s *FileSink) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *FileSink) Setup(context.Context) {
+	if s.config.Path == "" {
+		s.Logger.Fatal(fmt.Sprintf("output.%s.path is required", s.config.name))
+	}
+	opts, err := s.config.sinkOptions()
+	if err != nil {
+		s.Logger.Fatal(fmt.Sprintf("invalid output.%s", s.config.name), zap.Error(err))
+	}
+	s.opts = opts
+}
+
+func (s *FileSink) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *FileSink) Name() string {
+	return s.config.name
+}
+
+func (s *FileSink) SinkOptions() derperer.SinkOptions {
+	return s.opts
+}
+
+func (s *FileSink) Push(ctx context.Context, data []byte, contentType string) error {
+	return writeFileAtomic(s.config.Path, data)
+}
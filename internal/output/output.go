@@ -0,0 +1,74 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+// Config holds the settings shared by every output sink.
+type Config struct {
+	Enable   bool          `mapstructure:"enable"`
+	Format   string        `mapstructure:"format"`
+	Query    string        `mapstructure:"query"`
+	Debounce time.Duration `mapstructure:"debounce"`
+}
+
+func (c *Config) register(set *pflag.FlagSet, name string, format string, query string) {
+	set.Bool(fmt.Sprintf("output.%s.enable", name), false, fmt.Sprintf("Enable %s output", name))
+	set.String(fmt.Sprintf("output.%s.format", name), format, fmt.Sprintf("Format of %s output, json, yaml for Headscale or policy for the derpMap block of a Tailscale policy file", name))
+	set.String(fmt.Sprintf("output.%s.query", name), query, fmt.Sprintf("Query parameters of /derp.json to filter the map of %s output, e.g. status=available", name))
+	set.Duration(fmt.Sprintf("output.%s.debounce", name), 10*time.Second, fmt.Sprintf("Time to wait for further changes before pushing %s output", name))
+}
+
+// sinkOptions validates the config and converts it to the options of the
+// sink.
+func (c *Config) sinkOptions() (derperer.SinkOptions, error) {
+	switch c.Format {
+	case derperer.FormatJSON, derperer.FormatYAML, derperer.FormatPolicy:
+	default:
+		return derperer.SinkOptions{}, errors.Errorf("unknown format %q", c.Format)
+	}
+	query, err := derperer.ParseDerpQuery(c.Query)
+	if err != nil {
+		return derperer.SinkOptions{}, err
+	}
+	return derperer.SinkOptions{
+		Query:    query,
+		Format:   c.Format,
+		Debounce: c.Debounce,
+	}, nil
+}
+
+func settings(name string) any {
+	outputs, _ := viper.AllSettings()["output"].(map[string]any)
+	return outputs[name]
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// to path, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,83 @@
+package output
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+func TestFileSinkPush(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFile()
+	s.config.Path = filepath.Join(dir, "derp.json")
+	s.config.Format = derperer.FormatJSON
+	s.Setup(context.Background())
+
+	for _, data := range []string{`{"Regions":{"900":{}}}`, `{}`} {
+		if err := s.Push(context.Background(), []byte(data), "application/json"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(s.config.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("file holds %q, want %q", got, data)
+		}
+	}
+
+	info, err := os.Stat(s.config.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("file mode %s, want 0644", info.Mode().Perm())
+	}
+	// the temporary files are renamed or removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only derp.json", len(entries))
+	}
+}
+
+func TestWebhookSinkPush(t *testing.T) {
+	status := http.StatusNoContent
+	var method, auth, contentType, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		auth = r.Header.Get("Authorization")
+		contentType = r.Header.Get("Content-Type")
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	s := NewWebhook()
+	s.config.URL = ts.URL
+	s.config.Method = "put"
+	s.config.Headers = []string{"Authorization=Bearer secret"}
+	s.config.Format = derperer.FormatYAML
+	s.Setup(context.Background())
+
+	if err := s.Push(context.Background(), []byte("regions: {}\n"), "application/yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || auth != "Bearer secret" || contentType != "application/yaml" || body != "regions: {}\n" {
+		t.Errorf("received %s with Authorization %q, Content-Type %q and body %q", method, auth, contentType, body)
+	}
+
+	status = http.StatusBadGateway
+	if err := s.Push(context.Background(), []byte("regions: {}\n"), "application/yaml"); err == nil {
+		t.Error("no error for a 502 response")
+	}
+}
@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+var _ derperer.Sink = (*WebhookSink)(nil)
+var _ configuration.Configuration = (*webhookConfig)(nil)
+
+type webhookConfig struct {
+	Config `mapstructure:",squash"`
+
+	URL     string        `mapstructure:"url"`
+	Method  string        `mapstructure:"method"`
+	Headers []string      `mapstructure:"headers"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func (c *webhookConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "webhook", derperer.FormatJSON, "")
+	set.String("output.webhook.url", "", "URL webhook output sends the map to")
+	set.String("output.webhook.method", http.MethodPost, "HTTP method of webhook output, POST or PUT")
+	set.StringSlice("output.webhook.headers", nil, "Headers of webhook output requests as name=value, e.g. Authorization=Bearer secret")
+	set.Duration("output.webhook.timeout", 10*time.Second, "Timeout of webhook output requests")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *webhookConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("webhook"), c)
+}
+
+// WebhookSink sends the DERP map in the body of an HTTP request.
+type WebhookSink struct {
+	*application.EmptyApplication
+	config webhookConfig
+
+	opts    derperer.SinkOptions
+	headers http.Header
+	client  *http.Client
+}
+
+func NewWebhook() *WebhookSink {
+	return &WebhookSink{
+		EmptyApplication: application.NewEmptyApplication("WebhookSink"),
+	}
+}
+
+func (s *WebhookSink) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *WebhookSink) Setup(context.Context) {
+	if s.config.URL == "" {
+		s.Logger.Fatal("output.webhook.url is required")
+	}
+	s.config.Method = strings.ToUpper(s.config.Method)
+	if s.config.Method != http.MethodPost && s.config.Method != http.MethodPut {
+		s.Logger.Fatal("invalid output.webhook.method, expect POST or PUT", zap.String("method", s.config.Method))
+	}
+	s.headers = http.Header{}
+	for _, header := range s.config.Headers {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok || name == "" {
+			s.Logger.Fatal("invalid output.webhook.headers, expect name=value", zap.String("header", header))
+		}
+		s.headers.Add(name, value)
+	}
+	opts, err := s.config.sinkOptions()
+	if err != nil {
+		s.Logger.Fatal("invalid output.webhook", zap.Error(err))
+	}
+	s.opts = opts
+	s.client = &http.Client{Timeout: s.config.Timeout}
+}
+
+func (s *WebhookSink) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) SinkOptions() derperer.SinkOptions {
+	return s.opts
+}
+
+func (s *WebhookSink) Push(ctx context.Context, data []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, s.config.Method, s.config.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = s.headers.Clone()
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("%s %s: %s", s.config.Method, s.config.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+)
+
+const CENSYS_QUERY = `services.http.response.body: "<h1>DERP</h1>"`
+const CENSYS_QUERY_CN = `services.http.response.body: "<h1>DERP</h1>" and location.country_code: CN`
+
+// censysPageSize is the maximum number of hits of a Censys search page.
+const censysPageSize = 100
+
+// censysFields are the host fields returned for every hit.
+var censysFields = []string{
+	"ip",
+	"location.country_code",
+	"location.province",
+	"location.city",
+	"autonomous_system.asn",
+	"autonomous_system.name",
+	"services.port",
+	"services.extended_service_name",
+	"services.http.response.body",
+	"services.tls.certificates.leaf_data.names",
+	"services.tls.certificates.leaf_data.signature.self_signed",
+}
+
+var _ derperer.ScheduledSource = (*CensysSource)(nil)
+var _ configuration.Configuration = (*censysConfig)(nil)
+
+type censysConfig struct {
+	Config `mapstructure:",squash"`
+
+	APIID     string `mapstructure:"api_id"`
+	APISecret string `mapstructure:"api_secret"`
+	Endpoint  string `mapstructure:"endpoint"`
+}
+
+func (c *censysConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "censys", false)
+	set.String("source.censys.api_id", "", "Censys API ID")
+	set.String("source.censys.api_secret", "", "Censys API secret")
+	set.String("source.censys.endpoint", "https://search.censys.io/api", "Censys API endpoint")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *censysConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("censys"), c)
+}
+
+type CensysSource struct {
+	*application.EmptyApplication
+	config censysConfig
+}
+
+func NewCensys() *CensysSource {
+	return &CensysSource{
+		EmptyApplication: application.NewEmptyApplication("CensysSource"),
+	}
+}
+
+func (s *CensysSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *CensysSource) Setup(context.Context) {
+	if s.config.APIID == "" || s.config.APISecret == "" {
+		s.Logger.Fatal("source.censys.api_id and source.censys.api_secret are required")
+	}
+}
+
+func (s *CensysSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *CensysSource) Name() string {
+	return "censys"
+}
+
+func (s *CensysSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+type censysHit struct {
+	IP       string `json:"ip"`
+	Location struct {
+		CountryCode string `json:"country_code"`
+		Province    string `json:"province"`
+		City        string `json:"city"`
+	} `json:"location"`
+	AutonomousSystem struct {
+		ASN  int    `json:"asn"`
+		Name string `json:"name"`
+	} `json:"autonomous_system"`
+	Services []censysService `json:"services"`
+}
+
+type censysService struct {
+	Port                int    `json:"port"`
+	ExtendedServiceName string `json:"extended_service_name"`
+	HTTP                *struct {
+		Response struct {
+			Body string `json:"body"`
+		} `json:"response"`
+	} `json:"http"`
+	TLS *struct {
+		Certificates struct {
+			LeafData struct {
+				Names     []string `json:"names"`
+				Signature struct {
+					SelfSigned bool `json:"self_signed"`
+				} `json:"signature"`
+			} `json:"leaf_data"`
+		} `json:"certificates"`
+	} `json:"tls"`
+}
+
+// isDERP reports whether the service is a DERP server speaking TLS, hosts
+// match the query if any of their services serves the DERP landing page.
+func (s *censysService) isDERP() bool {
+	if s.TLS == nil && s.ExtendedServiceName != "HTTPS" {
+		return false
+	}
+	return s.HTTP != nil && strings.Contains(s.HTTP.Response.Body, DERP_LANDING_PAGE)
+}
+
+// certName returns a name of the leaf certificate which a client can verify
+// the service with, or an empty string.
+func (s *censysService) certName() string {
+	if s.TLS == nil || s.TLS.Certificates.LeafData.Signature.SelfSigned {
+		return ""
+	}
+	for _, name := range s.TLS.Certificates.LeafData.Names {
+		if name == "" || strings.Contains(name, "*") || net.ParseIP(name) != nil {
+			continue
+		}
+		return name
+	}
+	return ""
+}
+
+type censysSearchResult struct {
+	Code   int    `json:"code"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Result struct {
+		Total int         `json:"total"`
+		Hits  []censysHit `json:"hits"`
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"result"`
+}
+
+func (s *CensysSource) search(ctx context.Context, query string, cursor string) (*censysSearchResult, error) {
+	body, err := json.Marshal(map[string]any{
+		"q":        query,
+		"per_page": censysPageSize,
+		"cursor":   cursor,
+		"fields":   censysFields,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Endpoint+"/v2/hosts/search", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(s.config.APIID, s.config.APISecret)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res censysSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("decode censys response (%s): %w", resp.Status, err)
+	}
+	if res.Error != "" {
+		return nil, errors.Errorf("censys error response: %s", res.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("censys error response: %s", resp.Status)
+	}
+	return &res, nil
+}
+
+func (s *CensysSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	query := CENSYS_QUERY
+	if opts.ChinaOnly {
+		query = CENSYS_QUERY_CN
+	}
+
+	return searchPages(ctx, s.Logger, s.Name(), opts.Limit, func(ctx context.Context, _ int, cursor string) (*searchPage, error) {
+		res, err := s.search(ctx, query, cursor)
+		if err != nil {
+			return nil, err
+		}
+		result := &searchPage{
+			Cursor: res.Result.Links.Next,
+			Last:   res.Result.Links.Next == "" || len(res.Result.Hits) == 0,
+		}
+		for _, hit := range res.Result.Hits {
+			var asn string
+			if hit.AutonomousSystem.ASN != 0 {
+				asn = "AS" + strconv.Itoa(hit.AutonomousSystem.ASN)
+			}
+			for _, service := range hit.Services {
+				if !service.isDERP() {
+					continue
+				}
+				host := hit.IP
+				if name := service.certName(); name != "" {
+					host = name
+				}
+				result.Candidates = append(result.Candidates, &derperer.Candidate{
+					Host:    host,
+					Port:    service.Port,
+					IP:      net.ParseIP(hit.IP),
+					Country: hit.Location.CountryCode,
+					Region:  hit.Location.Province,
+					City:    hit.Location.City,
+					Org:     hit.AutonomousSystem.Name,
+					ASN:     asn,
+				})
+			}
+		}
+		return result, nil
+	})
+}
@@ -0,0 +1,67 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+func TestCensysFetchOnlyDERPServices(t *testing.T) {
+	pages := map[string]string{
+		"": `{"code": 200, "result": {"total": 2, "links": {"next": "page2"}, "hits": [{
+			"ip": "192.0.2.1",
+			"location": {"country_code": "DE", "city": "Berlin"},
+			"autonomous_system": {"asn": 64496, "name": "Example"},
+			"services": [
+				{"port": 443, "extended_service_name": "HTTPS", "http": {"response": {"body": "<html><h1>DERP</h1></html>"}},
+					"tls": {"certificates": {"leaf_data": {"names": ["derp.example.com", "*.example.com"]}}}},
+				{"port": 8443, "extended_service_name": "HTTPS", "http": {"response": {"body": "<h1>Admin</h1>"}}, "tls": {}},
+				{"port": 80, "extended_service_name": "HTTP", "http": {"response": {"body": "<h1>DERP</h1>"}}}
+			]}]}}`,
+		"page2": `{"code": 200, "result": {"total": 2, "links": {"next": ""}, "hits": [{
+			"ip": "192.0.2.2",
+			"services": [
+				{"port": 9443, "extended_service_name": "HTTPS", "http": {"response": {"body": "<h1>DERP</h1>"}},
+					"tls": {"certificates": {"leaf_data": {"names": ["192.0.2.2"], "signature": {"self_signed": true}}}}}
+			]}]}}`,
+	}
+	var cursors []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); r.URL.Path != "/v2/hosts/search" || id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Cursor string `json:"cursor"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		cursors = append(cursors, body.Cursor)
+		w.Write([]byte(pages[body.Cursor]))
+	}))
+	defer ts.Close()
+
+	s := NewCensys()
+	s.config.Endpoint = ts.URL
+	s.config.APIID = "id"
+	s.config.APISecret = "secret"
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) != 2 || cursors[1] != "page2" {
+		t.Errorf("requested cursors %q, want [\"\" \"page2\"]", cursors)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("%d candidates, want 2: %+v", len(candidates), candidates)
+	}
+	if c := candidates[0]; c.Host != "derp.example.com" || c.Port != 443 || c.ASN != "AS64496" || c.City != "Berlin" {
+		t.Errorf("unexpected first candidate %+v", c)
+	}
+	if c := candidates[1]; c.Host != "192.0.2.2" || c.Port != 9443 {
+		t.Errorf("unexpected second candidate %+v", c)
+	}
+}
@@ -0,0 +1,359 @@
+package source
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/sourcegraph/conc/pool"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+const CT_LOG_URL = "https://ct.googleapis.com/logs/us1/argon2026h2"
+
+// RFC 6962 entry types of a TimestampedEntry.
+const (
+	ctX509Entry    = 0
+	ctPrecertEntry = 1
+)
+
+var _ derperer.ScheduledSource = (*CTSource)(nil)
+var _ configuration.Configuration = (*ctConfig)(nil)
+
+type ctConfig struct {
+	Config `mapstructure:",squash"`
+
+	Log         string        `mapstructure:"log"`
+	Patterns    []string      `mapstructure:"patterns"`
+	Port        int           `mapstructure:"port"`
+	Backfill    int64         `mapstructure:"backfill"`
+	MaxEntries  int64         `mapstructure:"max_entries"`
+	Batch       int64         `mapstructure:"batch"`
+	Concurrency int           `mapstructure:"concurrency"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+func (c *ctConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "ct", false)
+	set.String("source.ct.log", CT_LOG_URL, "URL of the Certificate Transparency log")
+	set.StringSlice("source.ct.patterns", []string{"derp*"}, "Glob patterns of certificate names to check")
+	set.Int("source.ct.port", 443, "Port to check names on")
+	set.Int64("source.ct.backfill", 10000, "Number of entries before the log head to read on the first fetch")
+	set.Int64("source.ct.max_entries", 10000, "Maximum number of entries to read per fetch")
+	set.Int64("source.ct.batch", 256, "Number of entries per get-entries request")
+	set.Int("source.ct.concurrency", 10, "Maximum number of names verified concurrently")
+	set.Duration("source.ct.timeout", time.Second*5, "Timeout of resolving and verifying a name")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *ctConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("ct"), c)
+}
+
+// CTSource tails a Certificate Transparency log for certificates with names
+// matching the configured patterns, and reports the names which serve DERP
+// with a valid certificate.
+type CTSource struct {
+	*application.EmptyApplication
+	config ctConfig
+
+	// logClient queries the log, client verifies the names found in it
+	// and lookup resolves them.
+	logClient *http.Client
+	client    *http.Client
+	lookup    func(ctx context.Context, host string) ([]net.IPAddr, error)
+	// next is the index of the next log entry to read, -1 before the
+	// first fetch.
+	next int64
+	// pending are the names left unverified by the last fetch, once it
+	// found enough candidates.
+	pending []string
+}
+
+func NewCT() *CTSource {
+	return &CTSource{
+		EmptyApplication: application.NewEmptyApplication("CTSource"),
+		logClient:        http.DefaultClient,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		lookup: net.DefaultResolver.LookupIPAddr,
+		next:   -1,
+	}
+}
+
+func (s *CTSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *CTSource) Setup(context.Context) {
+	if s.config.Log == "" {
+		s.Logger.Fatal("source.ct.log is required")
+	}
+	for _, pattern := range s.config.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			s.Logger.Fatal("invalid source.ct.patterns", zap.String("pattern", pattern), zap.Error(err))
+		}
+	}
+	if s.config.Batch <= 0 || s.config.Concurrency <= 0 {
+		s.Logger.Fatal("source.ct.batch and source.ct.concurrency must be positive")
+	}
+}
+
+func (s *CTSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *CTSource) Name() string {
+	return "ct"
+}
+
+func (s *CTSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+type ctSTH struct {
+	TreeSize int64 `json:"tree_size"`
+}
+
+type ctEntries struct {
+	Entries []struct {
+		LeafInput []byte `json:"leaf_input"`
+		ExtraData []byte `json:"extra_data"`
+	} `json:"entries"`
+}
+
+func (s *CTSource) get(ctx context.Context, endpoint string, params url.Values, v any) error {
+	u := strings.TrimSuffix(s.config.Log, "/") + "/ct/v1/" + endpoint
+	if params != nil {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.logClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("ct log error response: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Errorf("decode ct log %s response: %w", endpoint, err)
+	}
+	return nil
+}
+
+// parseCTEntry returns the certificate or precertificate of a log entry.
+func parseCTEntry(leafInput, extraData []byte) (*x509.Certificate, error) {
+	// MerkleTreeLeaf: version, leaf type, TimestampedEntry with a 64 bit
+	// timestamp and 16 bit entry type
+	if len(leafInput) < 12 {
+		return nil, errors.Errorf("short ct leaf")
+	}
+	var der []byte
+	var err error
+	switch binary.BigEndian.Uint16(leafInput[10:12]) {
+	case ctX509Entry:
+		der, err = readUint24Bytes(leafInput[12:])
+	case ctPrecertEntry:
+		// the leaf only holds the TBSCertificate, the extra data
+		// starts with the full precertificate
+		der, err = readUint24Bytes(extraData)
+	default:
+		return nil, errors.Errorf("unknown ct entry type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func readUint24Bytes(b []byte) ([]byte, error) {
+	if len(b) < 3 {
+		return nil, errors.Errorf("short ct entry")
+	}
+	n := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	if len(b) < 3+n {
+		return nil, errors.Errorf("short ct entry")
+	}
+	return b[3 : 3+n], nil
+}
+
+func (s *CTSource) match(name string) bool {
+	if strings.Contains(name, "*") || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, pattern := range s.config.Patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// names reads the log entries since the last fetch and returns the matching
+// certificate names.
+func (s *CTSource) names(ctx context.Context) ([]string, error) {
+	derperer.SourceQueries.WithLabelValues(s.Name()).Inc()
+	var sth ctSTH
+	if err := s.get(ctx, "get-sth", nil, &sth); err != nil {
+		return nil, err
+	}
+	if s.next < 0 {
+		s.next = max(sth.TreeSize-s.config.Backfill, 0)
+	}
+	if s.next > sth.TreeSize {
+		// the log was replaced
+		s.next = max(sth.TreeSize-s.config.Backfill, 0)
+	}
+	end := sth.TreeSize
+	if s.config.MaxEntries > 0 && end-s.next > s.config.MaxEntries {
+		s.Logger.Warn("ct log is growing faster than it is read", zap.Int64("skipped", end-s.next-s.config.MaxEntries))
+		s.next = end - s.config.MaxEntries
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for s.next < end {
+		if err := ctx.Err(); err != nil {
+			return names, err
+		}
+		s.Logger.Debug("querying ct log", zap.Int64("start", s.next))
+		derperer.SourceQueries.WithLabelValues(s.Name()).Inc()
+		var entries ctEntries
+		err := s.get(ctx, "get-entries", url.Values{
+			"start": {strconv.FormatInt(s.next, 10)},
+			"end":   {strconv.FormatInt(min(s.next+s.config.Batch, end)-1, 10)},
+		}, &entries)
+		if err != nil {
+			return names, err
+		}
+		if len(entries.Entries) == 0 {
+			break
+		}
+		for _, entry := range entries.Entries {
+			cert, err := parseCTEntry(entry.LeafInput, entry.ExtraData)
+			if err != nil {
+				s.Logger.Debug("skip unparsable ct entry", zap.Error(err))
+				continue
+			}
+			for _, name := range cert.DNSNames {
+				name = strings.ToLower(name)
+				if !seen[name] && s.match(name) {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		s.next += int64(len(entries.Entries))
+	}
+	return names, nil
+}
+
+// Fetch verifies the names of the log entries since the last fetch, after
+// the names left over by it. Names are no longer verified once opts.Limit
+// candidates were found, they are left for the next fetch instead.
+func (s *CTSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	names, err := s.names(ctx)
+	seen := map[string]bool{}
+	names = slices.DeleteFunc(append(s.pending, names...), func(name string) bool {
+		defer func() { seen[name] = true }()
+		return seen[name]
+	})
+
+	p := pool.New().WithMaxGoroutines(s.config.Concurrency)
+	var mu sync.Mutex
+	var candidates []*derperer.Candidate
+	// left are the indexes of the names left for the next fetch
+	var left []int
+	for i, name := range names {
+		p.Go(func() {
+			mu.Lock()
+			full := len(candidates) >= opts.Limit
+			if full {
+				left = append(left, i)
+			}
+			mu.Unlock()
+			if full {
+				return
+			}
+
+			candidate := s.verify(ctx, name)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case candidate == nil && ctx.Err() != nil:
+				// not verified
+				left = append(left, i)
+			case candidate == nil:
+			case len(candidates) >= opts.Limit:
+				left = append(left, i)
+			default:
+				candidates = append(candidates, candidate)
+			}
+		})
+	}
+	p.Wait()
+
+	// keep the log order
+	slices.Sort(left)
+	s.pending = make([]string, 0, len(left))
+	for _, i := range left {
+		s.pending = append(s.pending, names[i])
+	}
+	return candidates, err
+}
+
+// verify resolves name and checks that it serves DERP with a certificate
+// valid for the name.
+func (s *CTSource) verify(ctx context.Context, name string) *derperer.Candidate {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	addrs, err := s.lookup(ctx, name)
+	if err != nil || len(addrs) == 0 {
+		s.Logger.Debug("skip unresolvable name", zap.String("name", name), zap.Error(err))
+		return nil
+	}
+	ip := addrs[0].IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ip = addr.IP
+			break
+		}
+	}
+
+	base := "https://" + net.JoinHostPort(name, strconv.Itoa(s.config.Port))
+	if !probeLanding(ctx, s.client, base) && !probeDerp(ctx, s.client, base) {
+		s.Logger.Debug("skip name without derp", zap.String("name", name))
+		return nil
+	}
+	return &derperer.Candidate{
+		Host: name,
+		Port: s.config.Port,
+		IP:   ip,
+	}
+}
@@ -0,0 +1,247 @@
+package source
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+// ctLeaf returns the RFC 6962 MerkleTreeLeaf of an x509 entry for a
+// certificate with the given names.
+func ctLeaf(t *testing.T, names ...string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := make([]byte, 12, 12+3+len(der)+2)
+	binary.BigEndian.PutUint64(leaf[2:10], uint64(time.Now().UnixMilli()))
+	binary.BigEndian.PutUint16(leaf[10:12], ctX509Entry)
+	leaf = append(leaf, byte(len(der)>>16), byte(len(der)>>8), byte(len(der)))
+	leaf = append(leaf, der...)
+	// no extensions
+	return append(leaf, 0, 0)
+}
+
+// fakeCTLog serves get-sth and get-entries for its leaves, returning at most
+// maxBatch entries per request like real logs do.
+type fakeCTLog struct {
+	mu       sync.Mutex
+	leaves   [][]byte
+	maxBatch int
+	// requested holds the start of each get-entries request.
+	requested []int
+}
+
+func (l *fakeCTLog) add(leaves ...[]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leaves = append(l.leaves, leaves...)
+}
+
+func (l *fakeCTLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch r.URL.Path {
+	case "/ct/v1/get-sth":
+		json.NewEncoder(w).Encode(ctSTH{TreeSize: int64(len(l.leaves))})
+	case "/ct/v1/get-entries":
+		start, err1 := strconv.Atoi(r.URL.Query().Get("start"))
+		end, err2 := strconv.Atoi(r.URL.Query().Get("end"))
+		if err1 != nil || err2 != nil || start > end || end >= len(l.leaves) {
+			http.Error(w, "bad range", http.StatusBadRequest)
+			return
+		}
+		l.requested = append(l.requested, start)
+		end = min(end, start+l.maxBatch-1)
+		var res ctEntries
+		for _, leaf := range l.leaves[start : end+1] {
+			res.Entries = append(res.Entries, struct {
+				LeafInput []byte `json:"leaf_input"`
+				ExtraData []byte `json:"extra_data"`
+			}{LeafInput: leaf})
+		}
+		json.NewEncoder(w).Encode(res)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestCT(t *testing.T, log *fakeCTLog) *CTSource {
+	t.Helper()
+	ts := httptest.NewServer(log)
+	t.Cleanup(ts.Close)
+	s := NewCT()
+	s.config.Log = ts.URL
+	s.config.Patterns = []string{"derp*"}
+	s.config.Batch = 4
+	s.config.Concurrency = 4
+	s.config.Timeout = time.Second
+	s.Setup(context.Background())
+	return s
+}
+
+func TestCTNames(t *testing.T) {
+	log := &fakeCTLog{maxBatch: 3}
+	for i := range 10 {
+		log.add(ctLeaf(t, "derp"+strconv.Itoa(i)+".example.com", "www.example.com"))
+	}
+	s := newTestCT(t, log)
+	s.config.Backfill = 6
+
+	names, err := s.names(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"derp4.example.com", "derp5.example.com", "derp6.example.com", "derp7.example.com", "derp8.example.com", "derp9.example.com"}
+	if !slices.Equal(names, want) {
+		t.Errorf("backfill read %v, want %v", names, want)
+	}
+	// batches of 4 are cut short to 3 by the log
+	if !slices.Equal(log.requested, []int{4, 7}) {
+		t.Errorf("requested entries from %v, want [4 7]", log.requested)
+	}
+
+	// later fetches only read the new entries
+	log.add(ctLeaf(t, "DERP10.example.com"), ctLeaf(t, "*.example.com", "192.0.2.1"))
+	log.requested = nil
+	names, err = s.names(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"derp10.example.com"}) || !slices.Equal(log.requested, []int{10}) {
+		t.Errorf("read %v from %v, want [derp10.example.com] from [10]", names, log.requested)
+	}
+
+	// entries beyond max_entries are skipped
+	for i := 12; i < 30; i++ {
+		log.add(ctLeaf(t, "derp"+strconv.Itoa(i)+".example.com"))
+	}
+	s.config.MaxEntries = 2
+	names, err = s.names(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"derp28.example.com", "derp29.example.com"}) {
+		t.Errorf("read %v, want the last 2 entries", names)
+	}
+}
+
+// serveDerp points the verification of s at a single server answering for
+// every name, which serves DERP for hosts. The httptest certificate is valid
+// for *.example.com only, derp3.example.com doesn't resolve.
+func serveDerp(t *testing.T, s *CTSource, hosts ...string) int {
+	t.Helper()
+	derp := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.Host)
+		if r.URL.Path == "/" && slices.Contains(hosts, host) {
+			w.Write([]byte(DERP_LANDING_PAGE))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(derp.Close)
+	port := derp.Listener.Addr().(*net.TCPAddr).Port
+	s.config.Port = port
+
+	s.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host == "derp3.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("::1")}, {IP: net.IPv4(127, 0, 0, 1)}}, nil
+	}
+	client := derp.Client()
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, derp.Listener.Addr().String())
+	}
+	client.Transport = transport
+	client.CheckRedirect = s.client.CheckRedirect
+	s.client = client
+	return port
+}
+
+func TestCTFetchVerifies(t *testing.T) {
+	log := &fakeCTLog{maxBatch: 10}
+	log.add(
+		ctLeaf(t, "derp1.example.com"),
+		ctLeaf(t, "derp2.example.com"),
+		ctLeaf(t, "derp3.example.com"),
+		ctLeaf(t, "derp.example.net"),
+	)
+	s := newTestCT(t, log)
+	s.config.Backfill = 10
+	port := serveDerp(t, s, "derp1.example.com")
+
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 {
+		t.Fatalf("%d candidates, want only derp1.example.com", len(candidates))
+	}
+	c := candidates[0]
+	if c.Host != "derp1.example.com" || c.Port != port || !c.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("unexpected candidate %+v", c)
+	}
+}
+
+func TestCTFetchKeepsNamesPastTheLimit(t *testing.T) {
+	log := &fakeCTLog{maxBatch: 10}
+	log.add(
+		ctLeaf(t, "derp1.example.com"),
+		ctLeaf(t, "derp2.example.com"),
+		ctLeaf(t, "derp4.example.com"),
+		ctLeaf(t, "derp5.example.com"),
+	)
+	s := newTestCT(t, log)
+	s.config.Backfill = 10
+	s.config.Concurrency = 1
+	serveDerp(t, s, "derp1.example.com", "derp2.example.com", "derp4.example.com", "derp5.example.com")
+
+	var hosts []string
+	for range 3 {
+		candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) > 2 {
+			t.Fatalf("%d candidates, want at most the limit of 2", len(candidates))
+		}
+		for _, c := range candidates {
+			hosts = append(hosts, c.Host)
+		}
+	}
+	slices.Sort(hosts)
+	if want := []string{"derp1.example.com", "derp2.example.com", "derp4.example.com", "derp5.example.com"}; !slices.Equal(hosts, want) {
+		t.Errorf("fetched %v, want every name once %v", hosts, want)
+	}
+}
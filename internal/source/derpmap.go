@@ -0,0 +1,129 @@
+package source
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/derperer/pkg/speedtest"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+const TAILSCALE_DERP_MAP_URL = "https://controlplane.tailscale.com/derpmap/default"
+
+var _ derperer.ScheduledSource = (*DERPMapSource)(nil)
+var _ configuration.Configuration = (*derpMapConfig)(nil)
+
+type derpMapConfig struct {
+	Config `mapstructure:",squash"`
+
+	URLs          []string `mapstructure:"urls"`
+	KeepRegionIDs bool     `mapstructure:"keep_region_ids"`
+}
+
+func (c *derpMapConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "derpmap", false)
+	set.StringSlice("source.derpmap.urls", []string{TAILSCALE_DERP_MAP_URL}, "URLs of the DERP maps to import")
+	set.Bool("source.derpmap.keep_region_ids", false, "Keep the region IDs and codes of imported DERP maps")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *derpMapConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("derpmap"), c)
+}
+
+// DERPMapSource imports the nodes of DERP maps, e.g. the default Tailscale
+// map, a Headscale map or the map of another derperer. Imported endpoints are
+// pinned, so they are never evicted while they are listed.
+type DERPMapSource struct {
+	*application.EmptyApplication
+	config derpMapConfig
+}
+
+func NewDERPMap() *DERPMapSource {
+	return &DERPMapSource{
+		EmptyApplication: application.NewEmptyApplication("DERPMapSource"),
+	}
+}
+
+func (s *DERPMapSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *DERPMapSource) Setup(context.Context) {
+	if len(s.config.URLs) == 0 {
+		s.Logger.Fatal("source.derpmap.urls is required")
+	}
+}
+
+func (s *DERPMapSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *DERPMapSource) Name() string {
+	return "derpmap"
+}
+
+func (s *DERPMapSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+func (s *DERPMapSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	var candidates []*derperer.Candidate
+	for _, url := range s.config.URLs {
+		if err := ctx.Err(); err != nil {
+			return candidates, err
+		}
+		s.Logger.Debug("fetching derp map", zap.String("url", url))
+		derperer.SourceQueries.WithLabelValues(s.Name()).Inc()
+		dmap, err := speedtest.FetchDERPMap(ctx, url)
+		if err != nil {
+			return candidates, err
+		}
+		for _, id := range slices.Sorted(maps.Keys(dmap.Regions)) {
+			region := dmap.Regions[id]
+			if region == nil {
+				continue
+			}
+			first := true
+			for _, node := range region.Nodes {
+				if node.STUNOnly || node.HostName == "" {
+					continue
+				}
+				ip := net.ParseIP(node.IPv4)
+				if ip == nil {
+					ip = net.ParseIP(node.IPv6)
+				}
+				candidate := &derperer.Candidate{
+					Host:     node.HostName,
+					Port:     cmp.Or(node.DERPPort, 443),
+					IP:       ip,
+					Pinned:   true,
+					Insecure: node.InsecureForTests,
+				}
+				if s.config.KeepRegionIDs {
+					candidate.NodeName = node.Name
+					// only the first node keeps the region, the others of a
+					// multi-node region get their own ID and code
+					if first {
+						candidate.RegionID = cmp.Or(region.RegionID, id)
+						candidate.RegionCode = region.RegionCode
+					}
+				}
+				first = false
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	// pinned endpoints are not subject to the limit
+	return candidates, nil
+}
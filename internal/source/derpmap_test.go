@@ -0,0 +1,54 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"tailscale.com/tailcfg"
+)
+
+func TestDERPMapFetchKeepsAllNodes(t *testing.T) {
+	dmap := &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{}}
+	for id := 1; id <= 60; id++ {
+		region := &tailcfg.DERPRegion{RegionID: id, RegionCode: fmt.Sprintf("r%d", id)}
+		for _, name := range []string{"a", "b"} {
+			region.Nodes = append(region.Nodes, &tailcfg.DERPNode{
+				Name:     fmt.Sprintf("%d%s", id, name),
+				RegionID: id,
+				HostName: fmt.Sprintf("derp%d%s.example.com", id, name),
+			})
+		}
+		dmap.Regions[id] = region
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(dmap)
+	}))
+	defer ts.Close()
+
+	s := NewDERPMap()
+	s.config.URLs = []string{ts.URL}
+	s.config.KeepRegionIDs = true
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 120 {
+		t.Fatalf("%d candidates, want all 120 nodes", len(candidates))
+	}
+	for i, c := range candidates {
+		id := i/2 + 1
+		// only the first node keeps the region
+		wantID, wantCode, wantName := id, fmt.Sprintf("r%d", id), fmt.Sprintf("%da", id)
+		if i%2 == 1 {
+			wantID, wantCode, wantName = 0, "", fmt.Sprintf("%db", id)
+		}
+		if c.RegionID != wantID || c.RegionCode != wantCode || c.NodeName != wantName || !c.Pinned {
+			t.Errorf("%s: region %d %q node %q pinned %t, want region %d %q node %q pinned", c.Host, c.RegionID, c.RegionCode, c.NodeName, c.Pinned, wantID, wantCode, wantName)
+		}
+	}
+}
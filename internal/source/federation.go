@@ -0,0 +1,154 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+var _ derperer.ScheduledSource = (*FederationSource)(nil)
+var _ configuration.Configuration = (*federationConfig)(nil)
+
+type federationConfig struct {
+	Config `mapstructure:",squash"`
+
+	Peers []string `mapstructure:"peers"`
+	Token string   `mapstructure:"token"`
+}
+
+func (c *federationConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "federation", false)
+	set.StringSlice("source.federation.peers", nil, "Federation peers as name=url")
+	set.String("source.federation.token", "", "Token presented to federation peers")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *federationConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("federation"), c)
+}
+
+type federationPeer struct {
+	name string
+	url  string
+}
+
+// FederationSource pulls the endpoints and check results of peer derperer
+// instances. Results are recorded under the name of the peer.
+type FederationSource struct {
+	*application.EmptyApplication
+	config federationConfig
+
+	peers []federationPeer
+}
+
+func NewFederation() *FederationSource {
+	return &FederationSource{
+		EmptyApplication: application.NewEmptyApplication("FederationSource"),
+	}
+}
+
+func (s *FederationSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *FederationSource) Setup(context.Context) {
+	if len(s.config.Peers) == 0 {
+		s.Logger.Fatal("source.federation.peers is required")
+	}
+	for _, peer := range s.config.Peers {
+		name, url, ok := strings.Cut(peer, "=")
+		if !ok || name == "" || url == "" {
+			s.Logger.Fatal("invalid source.federation.peers, expect name=url", zap.String("peer", peer))
+		}
+		s.peers = append(s.peers, federationPeer{name: name, url: strings.TrimSuffix(url, "/")})
+	}
+}
+
+func (s *FederationSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *FederationSource) Name() string {
+	return "federation"
+}
+
+func (s *FederationSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+func (s *FederationSource) export(ctx context.Context, peer federationPeer) (*derperer.FederationExport, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer.url+"/federation/export", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("federation peer %s error response: %s", peer.name, resp.Status)
+	}
+
+	var res derperer.FederationExport
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("decode federation peer %s response: %w", peer.name, err)
+	}
+	return &res, nil
+}
+
+// Fetch returns every endpoint of the peers, they are not limited. A peer
+// which fails doesn't hold back the others, its error is returned along with
+// the endpoints of the rest.
+func (s *FederationSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	var candidates []*derperer.Candidate
+	var errs []error
+	for _, peer := range s.peers {
+		if err := ctx.Err(); err != nil {
+			return candidates, err
+		}
+		s.Logger.Debug("pulling federation peer", zap.String("peer", peer.name))
+		derperer.SourceQueries.WithLabelValues(s.Name()).Inc()
+		res, err := s.export(ctx, peer)
+		if err != nil {
+			s.Logger.Warn("pull federation peer failed", zap.String("peer", peer.name), zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+		for _, endpoint := range res.Endpoints {
+			ip := net.ParseIP(endpoint.IPv4)
+			if ip == nil {
+				ip = net.ParseIP(endpoint.IPv6)
+			}
+			candidates = append(candidates, &derperer.Candidate{
+				Host:       endpoint.Host,
+				Port:       endpoint.Port,
+				IP:         ip,
+				Country:    endpoint.Country,
+				Region:     endpoint.Region,
+				City:       endpoint.City,
+				ASN:        endpoint.ASN,
+				Insecure:   endpoint.Insecure,
+				RegionID:   endpoint.OriginalID,
+				RegionCode: endpoint.Name,
+				NodeName:   endpoint.NodeName,
+				Peer:       peer.name,
+				Result:     endpoint.Result(),
+			})
+		}
+	}
+	return candidates, errors.Join(errs...)
+}
@@ -0,0 +1,52 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+func TestFederationFetchSkipsFailedPeers(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/federation/export" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(derperer.FederationExport{Endpoints: derperer.DerpEndpoints{{
+			Host:    "derp.example.com",
+			Port:    443,
+			IPv4:    "192.0.2.1",
+			Region:  "Tokyo",
+			Country: "JP",
+			City:    "Shibuya",
+			ASN:     "AS64496",
+			Status:  derperer.DerpStatusAvailable,
+		}}})
+	}))
+	defer up.Close()
+
+	s := NewFederation()
+	s.config.Peers = []string{"down=" + down.URL, "up=" + up.URL + "/"}
+	s.config.Token = "secret"
+	s.Setup(context.Background())
+
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{})
+	if err == nil {
+		t.Error("no error for the failed peer")
+	}
+	if len(candidates) != 1 {
+		t.Fatalf("%d candidates, want the endpoint of the peer which is up", len(candidates))
+	}
+	c := candidates[0]
+	if c.Peer != "up" || c.Region != "Tokyo" || c.Country != "JP" || c.City != "Shibuya" || c.ASN != "AS64496" || c.IP.String() != "192.0.2.1" {
+		t.Errorf("unexpected candidate %+v", c)
+	}
+}
@@ -0,0 +1,103 @@
+package source
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-app/fofa"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+const FINGERPRINT = `body="<h1>DERP</h1>"`
+const FINGERPRIINT_CN = `body="<h1>DERP</h1>" && country="CN"`
+
+var _ derperer.ScheduledSource = (*FofaSource)(nil)
+var _ configuration.Configuration = (*fofaConfig)(nil)
+
+type fofaConfig struct {
+	Config `mapstructure:",squash"`
+}
+
+func (c *fofaConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "fofa", true)
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *fofaConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("fofa"), c)
+}
+
+type FofaSource struct {
+	*application.EmptyApplication
+	config fofaConfig
+
+	Fofa *fofa.FofaApp `inject:""`
+}
+
+func NewFofa() *FofaSource {
+	return &FofaSource{
+		EmptyApplication: application.NewEmptyApplication("FofaSource"),
+	}
+}
+
+func (f *FofaSource) Configuration() configuration.Configuration {
+	return &f.config
+}
+
+func (f *FofaSource) Enabled() bool {
+	return f.config.Enable
+}
+
+func (f *FofaSource) Name() string {
+	return "fofa"
+}
+
+func (f *FofaSource) SourceOptions() derperer.SourceOptions {
+	return f.config.SourceOptions()
+}
+
+func (f *FofaSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	fingerprint := FINGERPRINT
+	if opts.ChinaOnly {
+		fingerprint = FINGERPRIINT_CN
+	}
+
+	return searchPages(ctx, f.Logger, f.Name(), opts.Limit, func(ctx context.Context, page int, _ string) (*searchPage, error) {
+		res, err := f.Fofa.Query(fingerprint, page, 100, fofa.WithExtraFields(
+			"country", "region", "city", "as_organization", "as_number",
+		))
+		if err != nil {
+			return nil, err
+		}
+		result := &searchPage{Last: len(res) == 0}
+		for _, asset := range res {
+			port, err := strconv.Atoi(asset.URL.Port())
+			if err != nil {
+				f.Logger.Debug("skip asset without port", zap.Stringer("url", asset.URL))
+				continue
+			}
+			var asn string
+			if asset.Raw["as_number"] != "" {
+				asn = "AS" + asset.Raw["as_number"]
+			}
+			result.Candidates = append(result.Candidates, &derperer.Candidate{
+				Host:    asset.URL.Hostname(),
+				Port:    port,
+				IP:      asset.IP,
+				Country: asset.Raw["country"],
+				Region:  asset.Raw["region"],
+				City:    asset.Raw["city"],
+				Org:     asset.Raw["as_organization"],
+				ASN:     asn,
+			})
+		}
+		return result, nil
+	})
+}
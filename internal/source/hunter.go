@@ -0,0 +1,181 @@
+package source
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+const HUNTER_QUERY = `web.body="<h1>DERP</h1>"`
+const HUNTER_QUERY_CN = `web.body="<h1>DERP</h1>" && ip.country="CN"`
+
+const hunterPageSize = 100
+
+var _ derperer.ScheduledSource = (*HunterSource)(nil)
+var _ configuration.Configuration = (*hunterConfig)(nil)
+
+type hunterConfig struct {
+	Config `mapstructure:",squash"`
+
+	Key      string `mapstructure:"key"`
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+func (c *hunterConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "hunter", false)
+	set.String("source.hunter.key", "", "Hunter API key")
+	set.String("source.hunter.endpoint", "https://hunter.qianxin.com", "Hunter API endpoint")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *hunterConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("hunter"), c)
+}
+
+type HunterSource struct {
+	*application.EmptyApplication
+	config hunterConfig
+}
+
+func NewHunter() *HunterSource {
+	return &HunterSource{
+		EmptyApplication: application.NewEmptyApplication("HunterSource"),
+	}
+}
+
+func (s *HunterSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *HunterSource) Setup(context.Context) {
+	if s.config.Key == "" {
+		s.Logger.Fatal("source.hunter.key is required")
+	}
+}
+
+func (s *HunterSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *HunterSource) Name() string {
+	return "hunter"
+}
+
+func (s *HunterSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+type hunterAsset struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Country  string `json:"country"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+	ISP      string `json:"isp"`
+	ASOrg    string `json:"as_org"`
+}
+
+type hunterSearchResult struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Total int           `json:"total"`
+		Arr   []hunterAsset `json:"arr"`
+	} `json:"data"`
+}
+
+func (s *HunterSource) search(ctx context.Context, query string, page int) (*hunterSearchResult, error) {
+	u, err := url.Parse(s.config.Endpoint + "/openApi/search")
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	params.Set("api-key", s.config.Key)
+	params.Set("search", base64.URLEncoding.EncodeToString([]byte(query)))
+	params.Set("page", strconv.Itoa(page))
+	params.Set("page_size", strconv.Itoa(hunterPageSize))
+	// web assets only
+	params.Set("is_web", "1")
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res hunterSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("decode hunter response (%s): %w", resp.Status, err)
+	}
+	if res.Code != http.StatusOK {
+		return nil, errors.Errorf("hunter error response: %d %s", res.Code, res.Message)
+	}
+	return &res, nil
+}
+
+func (s *HunterSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	query := HUNTER_QUERY
+	if opts.ChinaOnly {
+		query = HUNTER_QUERY_CN
+	}
+
+	return searchPages(ctx, s.Logger, s.Name(), opts.Limit, func(ctx context.Context, page int, _ string) (*searchPage, error) {
+		res, err := s.search(ctx, query, page)
+		if err != nil {
+			return nil, err
+		}
+		result := &searchPage{Last: len(res.Data.Arr) == 0 || page*hunterPageSize >= res.Data.Total}
+		for _, asset := range res.Data.Arr {
+			if strings.EqualFold(asset.Protocol, "http") {
+				s.Logger.Debug("skip asset without tls", zap.String("ip", asset.IP), zap.Int("port", asset.Port))
+				continue
+			}
+			result.Candidates = append(result.Candidates, &derperer.Candidate{
+				Host:    asset.IP,
+				Port:    asset.Port,
+				IP:      net.ParseIP(asset.IP),
+				Country: hunterCountry(asset.Country, asset.Province),
+				Region:  asset.Province,
+				City:    asset.City,
+				Org:     cmp.Or(asset.ISP, asset.ASOrg),
+			})
+		}
+		return result, nil
+	})
+}
+
+// hunterCountry returns the country code of a Hunter asset. Hunter reports
+// Hong Kong, Macau and Taiwan as provinces of China.
+func hunterCountry(country string, province string) string {
+	if country == "中国" {
+		if code, ok := hunterCountryCodes[province]; ok {
+			return code
+		}
+	}
+	if code, ok := hunterCountryCodes[country]; ok {
+		return code
+	}
+	return country
+}
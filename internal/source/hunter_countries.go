@@ -0,0 +1,263 @@
+package source
+
+// hunterCountryCodes maps the Chinese country names used by Hunter to the
+// ISO 3166-1 country codes reported by the other sources. The names are the
+// simplified Chinese names of CLDR, plus the short names of Hong Kong, Macau
+// and Taiwan.
+var hunterCountryCodes = map[string]string{
+	"不丹":          "BT",
+	"东帝汶":         "TL",
+	"中国":          "CN",
+	"中国台湾":        "TW",
+	"中国澳门":        "MO",
+	"中国澳门特别行政区":   "MO",
+	"中国香港":        "HK",
+	"中国香港特别行政区":   "HK",
+	"中非共和国":       "CF",
+	"丹麦":          "DK",
+	"乌克兰":         "UA",
+	"乌兹别克斯坦":      "UZ",
+	"乌干达":         "UG",
+	"乌拉圭":         "UY",
+	"乍得":          "TD",
+	"也门":          "YE",
+	"亚美尼亚":        "AM",
+	"以色列":         "IL",
+	"伊拉克":         "IQ",
+	"伊朗":          "IR",
+	"伯利兹":         "BZ",
+	"佛得角":         "CV",
+	"俄罗斯":         "RU",
+	"保加利亚":        "BG",
+	"克罗地亚":        "HR",
+	"关岛":          "GU",
+	"冈比亚":         "GM",
+	"冰岛":          "IS",
+	"几内亚":         "GN",
+	"几内亚比绍":       "GW",
+	"列支敦士登":       "LI",
+	"刚果（布）":       "CG",
+	"刚果（金）":       "CD",
+	"利比亚":         "LY",
+	"利比里亚":        "LR",
+	"加拿大":         "CA",
+	"加纳":          "GH",
+	"加蓬":          "GA",
+	"匈牙利":         "HU",
+	"北马里亚纳群岛":     "MP",
+	"南乔治亚和南桑威奇群岛": "GS",
+	"南极洲":         "AQ",
+	"南苏丹":         "SS",
+	"南非":          "ZA",
+	"博茨瓦纳":        "BW",
+	"卡塔尔":         "QA",
+	"卢旺达":         "RW",
+	"卢森堡":         "LU",
+	"印度":          "IN",
+	"印度尼西亚":       "ID",
+	"危地马拉":        "GT",
+	"厄瓜多尔":        "EC",
+	"厄立特里亚":       "ER",
+	"叙利亚":         "SY",
+	"古巴":          "CU",
+	"台湾":          "TW",
+	"吉尔吉斯斯坦":      "KG",
+	"吉布提":         "DJ",
+	"哈萨克斯坦":       "KZ",
+	"哥伦比亚":        "CO",
+	"哥斯达黎加":       "CR",
+	"喀麦隆":         "CM",
+	"图瓦卢":         "TV",
+	"土库曼斯坦":       "TM",
+	"土耳其":         "TR",
+	"圣卢西亚":        "LC",
+	"圣基茨和尼维斯":     "KN",
+	"圣多美和普林西比":    "ST",
+	"圣巴泰勒米":       "BL",
+	"圣文森特和格林纳丁斯":  "VC",
+	"圣皮埃尔和密克隆群岛":  "PM",
+	"圣诞岛":         "CX",
+	"圣赫勒拿":        "SH",
+	"圣马力诺":        "SM",
+	"圭亚那":         "GY",
+	"坦桑尼亚":        "TZ",
+	"埃及":          "EG",
+	"埃塞俄比亚":       "ET",
+	"基里巴斯":        "KI",
+	"塔吉克斯坦":       "TJ",
+	"塞内加尔":        "SN",
+	"塞尔维亚":        "RS",
+	"塞拉利昂":        "SL",
+	"塞浦路斯":        "CY",
+	"塞舌尔":         "SC",
+	"墨西哥":         "MX",
+	"多哥":          "TG",
+	"多米尼克":        "DM",
+	"多米尼加共和国":     "DO",
+	"奥兰群岛":        "AX",
+	"奥地利":         "AT",
+	"委内瑞拉":        "VE",
+	"孟加拉国":        "BD",
+	"安哥拉":         "AO",
+	"安圭拉":         "AI",
+	"安提瓜和巴布达":     "AG",
+	"安道尔":         "AD",
+	"密克罗尼西亚":      "FM",
+	"尼加拉瓜":        "NI",
+	"尼日利亚":        "NG",
+	"尼日尔":         "NE",
+	"尼泊尔":         "NP",
+	"巴勒斯坦领土":      "PS",
+	"巴哈马":         "BS",
+	"巴基斯坦":        "PK",
+	"巴巴多斯":        "BB",
+	"巴布亚新几内亚":     "PG",
+	"巴拉圭":         "PY",
+	"巴拿马":         "PA",
+	"巴林":          "BH",
+	"巴西":          "BR",
+	"布基纳法索":       "BF",
+	"布隆迪":         "BI",
+	"布韦岛":         "BV",
+	"希腊":          "GR",
+	"帕劳":          "PW",
+	"库克群岛":        "CK",
+	"库拉索":         "CW",
+	"开曼群岛":        "KY",
+	"德国":          "DE",
+	"意大利":         "IT",
+	"所罗门群岛":       "SB",
+	"托克劳":         "TK",
+	"拉脱维亚":        "LV",
+	"挪威":          "NO",
+	"捷克":          "CZ",
+	"摩尔多瓦":        "MD",
+	"摩洛哥":         "MA",
+	"摩纳哥":         "MC",
+	"文莱":          "BN",
+	"斐济":          "FJ",
+	"斯威士兰":        "SZ",
+	"斯洛伐克":        "SK",
+	"斯洛文尼亚":       "SI",
+	"斯瓦尔巴和扬马延":    "SJ",
+	"斯里兰卡":        "LK",
+	"新加坡":         "SG",
+	"新喀里多尼亚":      "NC",
+	"新西兰":         "NZ",
+	"日本":          "JP",
+	"智利":          "CL",
+	"朝鲜":          "KP",
+	"柬埔寨":         "KH",
+	"根西岛":         "GG",
+	"格林纳达":        "GD",
+	"格陵兰":         "GL",
+	"格鲁吉亚":        "GE",
+	"梵蒂冈":         "VA",
+	"比利时":         "BE",
+	"毛里塔尼亚":       "MR",
+	"毛里求斯":        "MU",
+	"汤加":          "TO",
+	"沙特阿拉伯":       "SA",
+	"法国":          "FR",
+	"法属南部领地":      "TF",
+	"法属圣马丁":       "MF",
+	"法属圭亚那":       "GF",
+	"法属波利尼西亚":     "PF",
+	"法罗群岛":        "FO",
+	"波兰":          "PL",
+	"波多黎各":        "PR",
+	"波斯尼亚和黑塞哥维那":  "BA",
+	"泰国":          "TH",
+	"泽西岛":         "JE",
+	"津巴布韦":        "ZW",
+	"洪都拉斯":        "HN",
+	"海地":          "HT",
+	"澳大利亚":        "AU",
+	"澳门":          "MO",
+	"爱尔兰":         "IE",
+	"爱沙尼亚":        "EE",
+	"牙买加":         "JM",
+	"特克斯和凯科斯群岛":   "TC",
+	"特立尼达和多巴哥":    "TT",
+	"玻利维亚":        "BO",
+	"瑙鲁":          "NR",
+	"瑞典":          "SE",
+	"瑞士":          "CH",
+	"瓜德罗普":        "GP",
+	"瓦利斯和富图纳":     "WF",
+	"瓦努阿图":        "VU",
+	"留尼汪":         "RE",
+	"白俄罗斯":        "BY",
+	"百慕大":         "BM",
+	"皮特凯恩群岛":      "PN",
+	"直布罗陀":        "GI",
+	"福克兰群岛":       "FK",
+	"科威特":         "KW",
+	"科摩罗":         "KM",
+	"科特迪瓦":        "CI",
+	"科科斯（基林）群岛":   "CC",
+	"科索沃":         "XK",
+	"秘鲁":          "PE",
+	"突尼斯":         "TN",
+	"立陶宛":         "LT",
+	"索马里":         "SO",
+	"约旦":          "JO",
+	"纳米比亚":        "NA",
+	"纽埃":          "NU",
+	"缅甸":          "MM",
+	"罗马尼亚":        "RO",
+	"美国":          "US",
+	"美国本土外小岛屿":    "UM",
+	"美属维尔京群岛":     "VI",
+	"美属萨摩亚":       "AS",
+	"老挝":          "LA",
+	"肯尼亚":         "KE",
+	"芬兰":          "FI",
+	"苏丹":          "SD",
+	"苏里南":         "SR",
+	"英国":          "GB",
+	"英属印度洋领地":     "IO",
+	"英属维尔京群岛":     "VG",
+	"荷兰":          "NL",
+	"荷属加勒比区":      "BQ",
+	"荷属圣马丁":       "SX",
+	"莫桑比克":        "MZ",
+	"莱索托":         "LS",
+	"菲律宾":         "PH",
+	"萨尔瓦多":        "SV",
+	"萨摩亚":         "WS",
+	"葡萄牙":         "PT",
+	"蒙古":          "MN",
+	"蒙特塞拉特":       "MS",
+	"西撒哈拉":        "EH",
+	"西班牙":         "ES",
+	"诺福克岛":        "NF",
+	"贝宁":          "BJ",
+	"赞比亚":         "ZM",
+	"赤道几内亚":       "GQ",
+	"赫德岛和麦克唐纳群岛":  "HM",
+	"越南":          "VN",
+	"阿塞拜疆":        "AZ",
+	"阿富汗":         "AF",
+	"阿尔及利亚":       "DZ",
+	"阿尔巴尼亚":       "AL",
+	"阿拉伯联合酋长国":    "AE",
+	"阿曼":          "OM",
+	"阿根廷":         "AR",
+	"阿鲁巴":         "AW",
+	"韩国":          "KR",
+	"香港":          "HK",
+	"马其顿":         "MK",
+	"马尔代夫":        "MV",
+	"马恩岛":         "IM",
+	"马拉维":         "MW",
+	"马提尼克":        "MQ",
+	"马来西亚":        "MY",
+	"马约特":         "YT",
+	"马绍尔群岛":       "MH",
+	"马耳他":         "MT",
+	"马达加斯加":       "MG",
+	"马里":          "ML",
+	"黎巴嫩":         "LB",
+	"黑山":          "ME",
+}
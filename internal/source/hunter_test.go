@@ -0,0 +1,85 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+func TestHunterCountry(t *testing.T) {
+	for _, tt := range []struct {
+		country, province string
+		want              string
+	}{
+		{"中国", "北京", "CN"},
+		{"中国", "香港", "HK"},
+		{"中国", "台湾", "TW"},
+		{"美国", "加利福尼亚", "US"},
+		{"日本", "东京", "JP"},
+		{"德国", "", "DE"},
+		{"英国", "", "GB"},
+		{"", "", ""},
+	} {
+		if got := hunterCountry(tt.country, tt.province); got != tt.want {
+			t.Errorf("hunterCountry(%q, %q) = %q, want %q", tt.country, tt.province, got, tt.want)
+		}
+	}
+}
+
+func TestHunterFetchPages(t *testing.T) {
+	assets := []hunterAsset{
+		{IP: "192.0.2.1", Port: 443, Protocol: "https", Country: "美国", Province: "加利福尼亚", City: "洛杉矶"},
+		{IP: "192.0.2.2", Port: 80, Protocol: "http", Country: "美国"},
+		{IP: "192.0.2.3", Port: 443, Protocol: "https", Country: "中国", Province: "香港", City: "香港"},
+	}
+	var pages []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		search, _ := base64.URLEncoding.DecodeString(query.Get("search"))
+		if r.URL.Path != "/openApi/search" || query.Get("api-key") != "secret" || string(search) != HUNTER_QUERY {
+			json.NewEncoder(w).Encode(hunterSearchResult{Code: http.StatusUnauthorized, Message: "bad request"})
+			return
+		}
+		page, _ := strconv.Atoi(query.Get("page"))
+		pages = append(pages, page)
+		res := hunterSearchResult{Code: http.StatusOK}
+		res.Data.Total = 2*hunterPageSize + len(assets)
+		// full pages of the first asset, then all of them
+		if page <= 2 {
+			for range hunterPageSize {
+				res.Data.Arr = append(res.Data.Arr, assets[0])
+			}
+		} else {
+			res.Data.Arr = assets
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	s := NewHunter()
+	s.config.Endpoint = ts.URL
+	s.config.Key = "secret"
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Errorf("requested pages %v, want [1 2 3]", pages)
+	}
+	if len(candidates) != 2*hunterPageSize+2 {
+		t.Fatalf("%d candidates, want %d", len(candidates), 2*hunterPageSize+2)
+	}
+	last := candidates[len(candidates)-2:]
+	if last[0].Country != "US" || last[0].City != "洛杉矶" {
+		t.Errorf("unexpected candidate %+v", last[0])
+	}
+	if last[1].Host != "192.0.2.3" || last[1].Country != "HK" {
+		t.Errorf("unexpected candidate %+v", last[1])
+	}
+}
@@ -0,0 +1,267 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/conc/pool"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// DERP_LANDING_PAGE is the marker of the landing page served by derper.
+const DERP_LANDING_PAGE = "<h1>DERP</h1>"
+
+const (
+	ScanCheckLanding = "landing"
+	ScanCheckProbe   = "probe"
+	ScanCheckAny     = "any"
+)
+
+// scanMaxHostBits caps the size of a single range, a /8 for IPv4.
+const scanMaxHostBits = 24
+
+var _ derperer.ScheduledSource = (*ScanSource)(nil)
+var _ configuration.Configuration = (*scanConfig)(nil)
+
+type scanConfig struct {
+	Config `mapstructure:",squash"`
+
+	Ranges      []string      `mapstructure:"ranges"`
+	Ports       []int         `mapstructure:"ports"`
+	Exclude     []string      `mapstructure:"exclude"`
+	Check       string        `mapstructure:"check"`
+	Rate        int           `mapstructure:"rate"`
+	Concurrency int           `mapstructure:"concurrency"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+func (c *scanConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "scan", false)
+	set.StringSlice("source.scan.ranges", nil, "CIDR ranges or IPs to scan")
+	set.IntSlice("source.scan.ports", []int{443}, "Ports to scan")
+	set.StringSlice("source.scan.exclude", nil, "CIDR ranges or IPs to never scan")
+	set.String("source.scan.check", ScanCheckLanding, "How to recognize DERP servers, landing for the landing page, probe for /derp/probe or any")
+	set.Int("source.scan.rate", 100, "Maximum number of probes per second")
+	set.Int("source.scan.concurrency", 50, "Maximum number of concurrent probes")
+	set.Duration("source.scan.timeout", time.Second*3, "Timeout of a single probe")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *scanConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("scan"), c)
+}
+
+// ScanSource probes address ranges for DERP servers over HTTPS.
+type ScanSource struct {
+	*application.EmptyApplication
+	config scanConfig
+
+	ranges  []netip.Prefix
+	exclude []netip.Prefix
+	client  *http.Client
+}
+
+func NewScan() *ScanSource {
+	return &ScanSource{
+		EmptyApplication: application.NewEmptyApplication("ScanSource"),
+		client: &http.Client{
+			Transport: &http.Transport{
+				// scanned servers are reported by IP, so their
+				// certificates can't be verified anyway
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (s *ScanSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *ScanSource) Setup(context.Context) {
+	if len(s.config.Ranges) == 0 {
+		s.Logger.Fatal("source.scan.ranges is required")
+	}
+	var err error
+	if s.ranges, err = parsePrefixes(s.config.Ranges); err != nil {
+		s.Logger.Fatal("invalid source.scan.ranges", zap.Error(err))
+	}
+	for _, prefix := range s.ranges {
+		if prefix.Addr().BitLen()-prefix.Bits() > scanMaxHostBits {
+			s.Logger.Fatal("source.scan.ranges is too large", zap.Stringer("range", prefix))
+		}
+	}
+	if s.exclude, err = parsePrefixes(s.config.Exclude); err != nil {
+		s.Logger.Fatal("invalid source.scan.exclude", zap.Error(err))
+	}
+	switch s.config.Check {
+	case ScanCheckLanding, ScanCheckProbe, ScanCheckAny:
+	default:
+		s.Logger.Fatal("invalid source.scan.check", zap.String("check", s.config.Check))
+	}
+	if s.config.Rate <= 0 || s.config.Concurrency <= 0 {
+		s.Logger.Fatal("source.scan.rate and source.scan.concurrency must be positive")
+	}
+}
+
+func (s *ScanSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *ScanSource) Name() string {
+	return "scan"
+}
+
+func (s *ScanSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+// parsePrefixes parses CIDR ranges, a single IP is a range of one address.
+func parsePrefixes(ss []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range ss {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (s *ScanSource) excluded(addr netip.Addr) bool {
+	for _, prefix := range s.exclude {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ScanSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	derperer.SourceQueries.WithLabelValues(s.Name()).Inc()
+
+	limiter := rate.NewLimiter(rate.Limit(s.config.Rate), 1)
+	p := pool.New().WithMaxGoroutines(s.config.Concurrency)
+
+	var mu sync.Mutex
+	var candidates []*derperer.Candidate
+	full := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(candidates) >= opts.Limit
+	}
+
+	var err error
+scan:
+	for _, prefix := range s.ranges {
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			if s.excluded(addr) {
+				continue
+			}
+			for _, port := range s.config.Ports {
+				if full() {
+					break scan
+				}
+				if err = limiter.Wait(ctx); err != nil {
+					break scan
+				}
+				p.Go(func() {
+					if !s.probe(ctx, addr, port) {
+						return
+					}
+					s.Logger.Debug("found derp server", zap.Stringer("addr", addr), zap.Int("port", port))
+					mu.Lock()
+					defer mu.Unlock()
+					candidates = append(candidates, &derperer.Candidate{
+						Host: addr.String(),
+						Port: port,
+						IP:   net.IP(addr.AsSlice()),
+					})
+				})
+			}
+		}
+	}
+	p.Wait()
+
+	if len(candidates) > opts.Limit {
+		candidates = candidates[:opts.Limit]
+	}
+	return candidates, err
+}
+
+// probe reports whether addr:port serves DERP over HTTPS.
+func (s *ScanSource) probe(ctx context.Context, addr netip.Addr, port int) bool {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	base := "https://" + net.JoinHostPort(addr.String(), strconv.Itoa(port))
+	switch s.config.Check {
+	case ScanCheckLanding:
+		return probeLanding(ctx, s.client, base)
+	case ScanCheckProbe:
+		return probeDerp(ctx, s.client, base)
+	default:
+		return probeLanding(ctx, s.client, base) || probeDerp(ctx, s.client, base)
+	}
+}
+
+// probeLanding reports whether base serves the DERP landing page.
+func probeLanding(ctx context.Context, client *http.Client, base string) bool {
+	resp, err := get(ctx, client, base+"/")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(body), DERP_LANDING_PAGE)
+}
+
+// probeDerp reports whether base answers the DERP probe endpoint.
+func probeDerp(ctx context.Context, client *http.Client, base string) bool {
+	resp, err := get(ctx, client, base+"/derp/probe")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
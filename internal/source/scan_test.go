@@ -0,0 +1,120 @@
+package source
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+// newTLSServer serves handler over TLS on loopback and returns its port.
+func newTLSServer(t *testing.T, handler http.HandlerFunc) int {
+	t.Helper()
+	ts := httptest.NewTLSServer(handler)
+	t.Cleanup(ts.Close)
+	return ts.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestScanFetch(t *testing.T) {
+	landing := newTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte("<html><body>\n" + DERP_LANDING_PAGE + "\n</body></html>"))
+			return
+		}
+		http.NotFound(w, r)
+	})
+	probe := newTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/derp/probe" {
+			return
+		}
+		http.NotFound(w, r)
+	})
+	other := newTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte("<h1>Hello</h1>"))
+			return
+		}
+		http.NotFound(w, r)
+	})
+	// a closed port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	for _, tt := range []struct {
+		check   string
+		exclude []string
+		limit   int
+		want    []int
+	}{
+		{check: ScanCheckLanding, limit: 10, want: []int{landing}},
+		{check: ScanCheckProbe, limit: 10, want: []int{probe}},
+		{check: ScanCheckAny, limit: 10, want: []int{landing, probe}},
+		{check: ScanCheckAny, exclude: []string{"127.0.0.1"}, limit: 10},
+	} {
+		s := NewScan()
+		s.config.Ranges = []string{"127.0.0.1/32"}
+		s.config.Ports = []int{landing, probe, other, closed}
+		s.config.Exclude = tt.exclude
+		s.config.Check = tt.check
+		s.config.Rate = 100
+		s.config.Concurrency = 4
+		s.config.Timeout = time.Second
+		s.Setup(context.Background())
+
+		candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: tt.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ports []int
+		for _, c := range candidates {
+			if c.Host != "127.0.0.1" || !c.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Errorf("unexpected candidate %+v", c)
+			}
+			ports = append(ports, c.Port)
+		}
+		slices.Sort(ports)
+		slices.Sort(tt.want)
+		if !slices.Equal(ports, tt.want) {
+			t.Errorf("check %s exclude %v: found ports %v, want %v", tt.check, tt.exclude, ports, tt.want)
+		}
+	}
+}
+
+func TestScanFetchRate(t *testing.T) {
+	var ports []int
+	for range 5 {
+		ports = append(ports, newTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(DERP_LANDING_PAGE))
+		}))
+	}
+	s := NewScan()
+	s.config.Ranges = []string{"127.0.0.1"}
+	s.config.Ports = ports
+	s.config.Check = ScanCheckLanding
+	s.config.Rate = 10
+	s.config.Concurrency = 10
+	s.config.Timeout = time.Second
+	s.Setup(context.Background())
+
+	start := time.Now()
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 5 {
+		t.Errorf("%d candidates, want 5", len(candidates))
+	}
+	// the first probe starts at once, the other 4 are 100ms apart
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("5 probes at 10/s took %s", elapsed)
+	}
+}
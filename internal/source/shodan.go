@@ -0,0 +1,168 @@
+package source
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+const SHODAN_QUERY = `http.html:"<h1>DERP</h1>"`
+const SHODAN_QUERY_CN = `http.html:"<h1>DERP</h1>" country:CN`
+
+// shodanPageSize is the fixed number of matches of a Shodan search page.
+const shodanPageSize = 100
+
+var _ derperer.ScheduledSource = (*ShodanSource)(nil)
+var _ configuration.Configuration = (*shodanConfig)(nil)
+
+type shodanConfig struct {
+	Config `mapstructure:",squash"`
+
+	Key      string `mapstructure:"key"`
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+func (c *shodanConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "shodan", false)
+	set.String("source.shodan.key", "", "Shodan API key")
+	set.String("source.shodan.endpoint", "https://api.shodan.io", "Shodan API endpoint")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *shodanConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("shodan"), c)
+}
+
+type ShodanSource struct {
+	*application.EmptyApplication
+	config shodanConfig
+}
+
+func NewShodan() *ShodanSource {
+	return &ShodanSource{
+		EmptyApplication: application.NewEmptyApplication("ShodanSource"),
+	}
+}
+
+func (s *ShodanSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *ShodanSource) Setup(context.Context) {
+	if s.config.Key == "" {
+		s.Logger.Fatal("source.shodan.key is required")
+	}
+}
+
+func (s *ShodanSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *ShodanSource) Name() string {
+	return "shodan"
+}
+
+func (s *ShodanSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+type shodanMatch struct {
+	IP        string   `json:"ip_str"`
+	Port      int      `json:"port"`
+	Hostnames []string `json:"hostnames"`
+	ASN       string   `json:"asn"`
+	Org       string   `json:"org"`
+	ISP       string   `json:"isp"`
+	Location  struct {
+		CountryCode string `json:"country_code"`
+		RegionCode  string `json:"region_code"`
+		City        string `json:"city"`
+	} `json:"location"`
+	SSL *json.RawMessage `json:"ssl"`
+}
+
+type shodanSearchResult struct {
+	Matches []shodanMatch `json:"matches"`
+	Total   int           `json:"total"`
+	Error   string        `json:"error"`
+}
+
+func (s *ShodanSource) search(ctx context.Context, query string, page int) (*shodanSearchResult, error) {
+	u, err := url.Parse(s.config.Endpoint + "/shodan/host/search")
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	params.Set("key", s.config.Key)
+	params.Set("query", query)
+	params.Set("page", strconv.Itoa(page))
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res shodanSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("decode shodan response (%s): %w", resp.Status, err)
+	}
+	if res.Error != "" {
+		return nil, errors.Errorf("shodan error response: %s", res.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("shodan error response: %s", resp.Status)
+	}
+	return &res, nil
+}
+
+func (s *ShodanSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	query := SHODAN_QUERY
+	if opts.ChinaOnly {
+		query = SHODAN_QUERY_CN
+	}
+
+	return searchPages(ctx, s.Logger, s.Name(), opts.Limit, func(ctx context.Context, page int, _ string) (*searchPage, error) {
+		res, err := s.search(ctx, query, page)
+		if err != nil {
+			return nil, err
+		}
+		result := &searchPage{Last: len(res.Matches) == 0 || page*shodanPageSize >= res.Total}
+		for _, match := range res.Matches {
+			if match.SSL == nil {
+				s.Logger.Debug("skip match without tls", zap.String("ip", match.IP), zap.Int("port", match.Port))
+				continue
+			}
+			result.Candidates = append(result.Candidates, &derperer.Candidate{
+				Host:    match.IP,
+				Port:    match.Port,
+				IP:      net.ParseIP(match.IP),
+				Country: match.Location.CountryCode,
+				Region:  match.Location.RegionCode,
+				City:    match.Location.City,
+				Org:     cmp.Or(match.Org, match.ISP),
+				ASN:     match.ASN,
+			})
+		}
+		return result, nil
+	})
+}
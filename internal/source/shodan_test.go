@@ -0,0 +1,95 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+// fakeShodan serves total matches in pages of shodanPageSize, every tenth
+// match without TLS, and records the requested pages.
+func fakeShodan(t *testing.T, total int, pages *[]int) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shodan/host/search" || r.URL.Query().Get("key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid key"})
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		*pages = append(*pages, page)
+		res := shodanSearchResult{Total: total, Matches: []shodanMatch{}}
+		for i := (page - 1) * shodanPageSize; i < min(page*shodanPageSize, total); i++ {
+			match := shodanMatch{IP: fmt.Sprintf("192.0.2.%d", i%256), Port: 443 + i, ASN: "AS64496"}
+			match.Location.CountryCode = "JP"
+			if i%10 != 9 {
+				ssl := json.RawMessage(`{}`)
+				match.SSL = &ssl
+			}
+			res.Matches = append(res.Matches, match)
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestShodanFetchPages(t *testing.T) {
+	for _, tt := range []struct {
+		total     int
+		limit     int
+		wantPages []int
+		want      int
+	}{
+		// every page holds 90 TLS matches
+		{total: 250, limit: 1000, wantPages: []int{1, 2, 3}, want: 225},
+		{total: 250, limit: 150, wantPages: []int{1, 2}, want: 150},
+		{total: 250, limit: 90, wantPages: []int{1}, want: 90},
+		{total: 0, limit: 100, wantPages: []int{1}, want: 0},
+	} {
+		t.Run(fmt.Sprintf("total %d limit %d", tt.total, tt.limit), func(t *testing.T) {
+			var pages []int
+			ts := fakeShodan(t, tt.total, &pages)
+			s := NewShodan()
+			s.config.Endpoint = ts.URL
+			s.config.Key = "secret"
+
+			candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: tt.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(pages) != fmt.Sprint(tt.wantPages) {
+				t.Errorf("requested pages %v, want %v", pages, tt.wantPages)
+			}
+			if len(candidates) != tt.want {
+				t.Fatalf("%d candidates, want %d", len(candidates), tt.want)
+			}
+			for _, c := range candidates {
+				if (c.Port-443)%10 == 9 {
+					t.Errorf("candidate %s:%d without tls", c.Host, c.Port)
+				}
+				if c.Country != "JP" || c.ASN != "AS64496" || c.IP == nil {
+					t.Errorf("candidate %s:%d not mapped: %+v", c.Host, c.Port, c)
+				}
+			}
+		})
+	}
+}
+
+func TestShodanFetchError(t *testing.T) {
+	var pages []int
+	ts := fakeShodan(t, 100, &pages)
+	s := NewShodan()
+	s.config.Endpoint = ts.URL
+	s.config.Key = "wrong"
+
+	if _, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 100}); err == nil {
+		t.Fatal("want error for invalid key")
+	}
+}
@@ -0,0 +1,79 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"go.uber.org/zap"
+)
+
+// Config holds the settings shared by every discovery source.
+type Config struct {
+	Enable   bool          `mapstructure:"enable"`
+	Interval time.Duration `mapstructure:"interval"`
+	Limit    int           `mapstructure:"limit"`
+}
+
+func (c *Config) register(set *pflag.FlagSet, name string, enable bool) {
+	set.Bool(fmt.Sprintf("source.%s.enable", name), enable, fmt.Sprintf("Enable %s discovery source", name))
+	set.Duration(fmt.Sprintf("source.%s.interval", name), 0, fmt.Sprintf("Refetch interval of %s source, 0 for derperer.refetch_interval", name))
+	set.Int(fmt.Sprintf("source.%s.limit", name), 0, fmt.Sprintf("Result limit of %s source, 0 for derperer.fetch_limit", name))
+}
+
+func (c *Config) SourceOptions() derperer.SourceOptions {
+	return derperer.SourceOptions{
+		Interval: c.Interval,
+		Limit:    c.Limit,
+	}
+}
+
+func settings(name string) any {
+	sources, _ := viper.AllSettings()["source"].(map[string]any)
+	return sources[name]
+}
+
+// searchPage is one page of search engine results.
+type searchPage struct {
+	Candidates []*derperer.Candidate
+	// Cursor is passed to the search of the next page by engines paging by
+	// cursor instead of page number.
+	Cursor string
+	// Last is set if there are no further pages.
+	Last bool
+}
+
+// searchFunc fetches page, counted from 1, of a search engine. cursor is the
+// Cursor of the previous page. Services the engine reports without TLS are
+// skipped, since the DERP checker only speaks TLS.
+type searchFunc func(ctx context.Context, page int, cursor string) (*searchPage, error)
+
+// searchPages fetches the pages of a search engine until limit candidates were
+// found or the last page was reached.
+func searchPages(ctx context.Context, logger *zap.Logger, name string, limit int, search searchFunc) ([]*derperer.Candidate, error) {
+	var candidates []*derperer.Candidate
+	cursor := ""
+	for page := 1; len(candidates) < limit; page++ {
+		if err := ctx.Err(); err != nil {
+			return candidates, err
+		}
+		logger.Debug("querying "+name, zap.Int("page", page))
+		derperer.SourceQueries.WithLabelValues(name).Inc()
+		res, err := search(ctx, page, cursor)
+		if err != nil {
+			return candidates, err
+		}
+		candidates = append(candidates, res.Candidates...)
+		if res.Last {
+			break
+		}
+		cursor = res.Cursor
+	}
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
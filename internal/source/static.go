@@ -0,0 +1,233 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var _ derperer.ScheduledSource = (*StaticSource)(nil)
+var _ derperer.WatchedSource = (*StaticSource)(nil)
+var _ configuration.Configuration = (*staticConfig)(nil)
+
+type staticConfig struct {
+	Config `mapstructure:",squash"`
+
+	Endpoints []string `mapstructure:"endpoints"`
+	File      string   `mapstructure:"file"`
+}
+
+func (c *staticConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "static", false)
+	set.StringSlice("source.static.endpoints", nil, "Endpoints of static source as host:port")
+	set.String("source.static.file", "", "YAML, JSON or host:port list file of static source, reloaded on change")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *staticConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("static"), c)
+}
+
+// StaticSource publishes endpoints listed in the configuration or a file.
+// Its endpoints are pinned, so they are never evicted.
+type StaticSource struct {
+	*application.EmptyApplication
+	config staticConfig
+}
+
+func NewStatic() *StaticSource {
+	return &StaticSource{
+		EmptyApplication: application.NewEmptyApplication("StaticSource"),
+	}
+}
+
+func (s *StaticSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *StaticSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *StaticSource) Name() string {
+	return "static"
+}
+
+func (s *StaticSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+// staticEndpoint is an entry of a YAML or JSON file, either a host:port
+// string or an object.
+type staticEndpoint struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	IP       string `yaml:"ip"`
+	Insecure bool   `yaml:"insecure"`
+	Country  string `yaml:"country"`
+	Region   string `yaml:"region"`
+	City     string `yaml:"city"`
+	Org      string `yaml:"org"`
+}
+
+func (e *staticEndpoint) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		host, port, err := splitHostPort(node.Value)
+		if err != nil {
+			return err
+		}
+		*e = staticEndpoint{Host: host, Port: port}
+		return nil
+	}
+	type plain staticEndpoint
+	return node.Decode((*plain)(e))
+}
+
+func (e *staticEndpoint) candidate() *derperer.Candidate {
+	return &derperer.Candidate{
+		Host:     e.Host,
+		Port:     e.Port,
+		IP:       net.ParseIP(e.IP),
+		Country:  e.Country,
+		Region:   e.Region,
+		City:     e.City,
+		Org:      e.Org,
+		Pinned:   true,
+		Insecure: e.Insecure,
+	}
+}
+
+// splitHostPort parses host:port, the port defaults to 443.
+func splitHostPort(s string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		// no port, or an IPv6 address without brackets
+		if ip := net.ParseIP(s); ip != nil || !strings.Contains(s, ":") {
+			return s, 443, nil
+		}
+		return "", 0, errors.Errorf("invalid endpoint %q: %w", s, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, errors.Errorf("invalid port of endpoint %q: %w", s, err)
+	}
+	return host, port, nil
+}
+
+func (s *StaticSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	var candidates []*derperer.Candidate
+	for _, endpoint := range s.config.Endpoints {
+		host, port, err := splitHostPort(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, (&staticEndpoint{Host: host, Port: port}).candidate())
+	}
+	if s.config.File != "" {
+		endpoints, err := readStaticFile(s.config.File)
+		if err != nil {
+			return candidates, err
+		}
+		for _, endpoint := range endpoints {
+			candidates = append(candidates, endpoint.candidate())
+		}
+	}
+	// pinned endpoints are not subject to the limit
+	return candidates, nil
+}
+
+func readStaticFile(path string) ([]*staticEndpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []*staticEndpoint
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		if err := yaml.Unmarshal(data, &endpoints); err != nil {
+			return nil, errors.Errorf("parse %s: %w", path, err)
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			host, port, err := splitHostPort(line)
+			if err != nil {
+				return nil, errors.Errorf("parse %s: %w", path, err)
+			}
+			endpoints = append(endpoints, &staticEndpoint{Host: host, Port: port})
+		}
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.Host == "" {
+			return nil, errors.Errorf("parse %s: endpoint without host", path)
+		}
+		if endpoint.Port == 0 {
+			endpoint.Port = 443
+		}
+	}
+	return endpoints, nil
+}
+
+// Changes reports changes of the endpoint file. The directory is watched,
+// so that files replaced by editors or config management are picked up.
+func (s *StaticSource) Changes(ctx context.Context) <-chan struct{} {
+	if s.config.File == "" {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.Logger.Error("failed to watch endpoint file", zap.Error(err))
+		return nil
+	}
+	path := filepath.Clean(s.config.File)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		s.Logger.Error("failed to watch endpoint file", zap.String("file", path), zap.Error(err))
+		watcher.Close()
+		return nil
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) {
+					continue
+				}
+				s.Logger.Debug("endpoint file changed", zap.Stringer("event", event))
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case err := <-watcher.Errors:
+				s.Logger.Error("failed to watch endpoint file", zap.Error(err))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
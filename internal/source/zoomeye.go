@@ -0,0 +1,179 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/yoshino-s/derperer/internal/derperer"
+	"github.com/yoshino-s/go-framework/application"
+	"github.com/yoshino-s/go-framework/configuration"
+	"github.com/yoshino-s/go-framework/utils"
+	"go.uber.org/zap"
+)
+
+const ZOOMEYE_QUERY = `http.body="<h1>DERP</h1>"`
+const ZOOMEYE_QUERY_CN = `http.body="<h1>DERP</h1>" && country="CN"`
+
+const zoomeyePageSize = 100
+
+// zoomeyeSuccess is the code of a successful ZoomEye response.
+const zoomeyeSuccess = 60000
+
+var _ derperer.ScheduledSource = (*ZoomEyeSource)(nil)
+var _ configuration.Configuration = (*zoomeyeConfig)(nil)
+
+type zoomeyeConfig struct {
+	Config `mapstructure:",squash"`
+
+	Key      string `mapstructure:"key"`
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+func (c *zoomeyeConfig) Register(set *pflag.FlagSet) {
+	c.register(set, "zoomeye", false)
+	set.String("source.zoomeye.key", "", "ZoomEye API key")
+	set.String("source.zoomeye.endpoint", "https://api.zoomeye.ai", "ZoomEye API endpoint")
+	utils.MustNoError(viper.BindPFlags(set))
+	configuration.Register(c)
+}
+
+func (c *zoomeyeConfig) Read() {
+	utils.MustDecodeFromMapstructure(settings("zoomeye"), c)
+}
+
+type ZoomEyeSource struct {
+	*application.EmptyApplication
+	config zoomeyeConfig
+}
+
+func NewZoomEye() *ZoomEyeSource {
+	return &ZoomEyeSource{
+		EmptyApplication: application.NewEmptyApplication("ZoomEyeSource"),
+	}
+}
+
+func (s *ZoomEyeSource) Configuration() configuration.Configuration {
+	return &s.config
+}
+
+func (s *ZoomEyeSource) Setup(context.Context) {
+	if s.config.Key == "" {
+		s.Logger.Fatal("source.zoomeye.key is required")
+	}
+}
+
+func (s *ZoomEyeSource) Enabled() bool {
+	return s.config.Enable
+}
+
+func (s *ZoomEyeSource) Name() string {
+	return "zoomeye"
+}
+
+func (s *ZoomEyeSource) SourceOptions() derperer.SourceOptions {
+	return s.config.SourceOptions()
+}
+
+type zoomeyeAsset struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Service  string `json:"service"`
+	Country  string `json:"country.name"`
+	Province string `json:"province.name"`
+	City     string `json:"city.name"`
+	ISP      string `json:"isp.name"`
+	ASN      int    `json:"asn"`
+}
+
+type zoomeyeSearchResult struct {
+	Code    int            `json:"code"`
+	Message string         `json:"message"`
+	Total   int            `json:"total"`
+	Data    []zoomeyeAsset `json:"data"`
+}
+
+func (s *ZoomEyeSource) search(ctx context.Context, query string, page int) (*zoomeyeSearchResult, error) {
+	body, err := json.Marshal(map[string]any{
+		"qbase64":  base64.StdEncoding.EncodeToString([]byte(query)),
+		"page":     page,
+		"pagesize": zoomeyePageSize,
+		"fields":   "ip,port,service,country.name,province.name,city.name,isp.name,asn",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Endpoint+"/v2/search", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("API-KEY", s.config.Key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res zoomeyeSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("decode zoomeye response (%s): %w", resp.Status, err)
+	}
+	if res.Code != zoomeyeSuccess {
+		return nil, errors.Errorf("zoomeye error response: %d %s", res.Code, res.Message)
+	}
+	return &res, nil
+}
+
+func (s *ZoomEyeSource) Fetch(ctx context.Context, opts derperer.FetchOptions) ([]*derperer.Candidate, error) {
+	query := ZOOMEYE_QUERY
+	if opts.ChinaOnly {
+		query = ZOOMEYE_QUERY_CN
+	}
+
+	return searchPages(ctx, s.Logger, s.Name(), opts.Limit, func(ctx context.Context, page int, _ string) (*searchPage, error) {
+		res, err := s.search(ctx, query, page)
+		if err != nil {
+			return nil, err
+		}
+		result := &searchPage{Last: len(res.Data) == 0 || page*zoomeyePageSize >= res.Total}
+		for _, asset := range res.Data {
+			if strings.EqualFold(asset.Service, "http") {
+				s.Logger.Debug("skip asset without tls", zap.String("ip", asset.IP), zap.Int("port", asset.Port))
+				continue
+			}
+			var asn string
+			if asset.ASN != 0 {
+				asn = "AS" + strconv.Itoa(asset.ASN)
+			}
+			result.Candidates = append(result.Candidates, &derperer.Candidate{
+				Host:    asset.IP,
+				Port:    asset.Port,
+				IP:      net.ParseIP(asset.IP),
+				Country: zoomeyeCountry(asset.Country),
+				Region:  asset.Province,
+				City:    asset.City,
+				Org:     asset.ISP,
+				ASN:     asn,
+			})
+		}
+		return result, nil
+	})
+}
+
+// zoomeyeCountry returns the country code of a ZoomEye asset, ZoomEye reports
+// the English country name.
+func zoomeyeCountry(country string) string {
+	if code, ok := zoomeyeCountryCodes[country]; ok {
+		return code
+	}
+	return country
+}
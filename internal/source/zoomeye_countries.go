@@ -0,0 +1,295 @@
+package source
+
+// zoomeyeCountryCodes maps the English country names used by ZoomEye to the
+// ISO 3166-1 country codes reported by the other sources. The names are the
+// English names of CLDR, plus the common short and official names CLDR
+// spells differently.
+var zoomeyeCountryCodes = map[string]string{
+	"Afghanistan":                            "AF",
+	"Albania":                                "AL",
+	"Algeria":                                "DZ",
+	"American Samoa":                         "AS",
+	"Andorra":                                "AD",
+	"Angola":                                 "AO",
+	"Anguilla":                               "AI",
+	"Antarctica":                             "AQ",
+	"Antigua & Barbuda":                      "AG",
+	"Antigua and Barbuda":                    "AG",
+	"Argentina":                              "AR",
+	"Armenia":                                "AM",
+	"Aruba":                                  "AW",
+	"Australia":                              "AU",
+	"Austria":                                "AT",
+	"Azerbaijan":                             "AZ",
+	"Bahamas":                                "BS",
+	"Bahrain":                                "BH",
+	"Bangladesh":                             "BD",
+	"Barbados":                               "BB",
+	"Belarus":                                "BY",
+	"Belgium":                                "BE",
+	"Belize":                                 "BZ",
+	"Benin":                                  "BJ",
+	"Bermuda":                                "BM",
+	"Bhutan":                                 "BT",
+	"Bolivia":                                "BO",
+	"Bosnia & Herzegovina":                   "BA",
+	"Bosnia and Herzegovina":                 "BA",
+	"Botswana":                               "BW",
+	"Bouvet Island":                          "BV",
+	"Brazil":                                 "BR",
+	"British Indian Ocean Territory":         "IO",
+	"British Virgin Islands":                 "VG",
+	"Brunei":                                 "BN",
+	"Brunei Darussalam":                      "BN",
+	"Bulgaria":                               "BG",
+	"Burkina Faso":                           "BF",
+	"Burundi":                                "BI",
+	"Cabo Verde":                             "CV",
+	"Cambodia":                               "KH",
+	"Cameroon":                               "CM",
+	"Canada":                                 "CA",
+	"Cape Verde":                             "CV",
+	"Caribbean Netherlands":                  "BQ",
+	"Cayman Islands":                         "KY",
+	"Central African Republic":               "CF",
+	"Chad":                                   "TD",
+	"Chile":                                  "CL",
+	"China":                                  "CN",
+	"Christmas Island":                       "CX",
+	"Cocos (Keeling) Islands":                "CC",
+	"Colombia":                               "CO",
+	"Comoros":                                "KM",
+	"Congo":                                  "CG",
+	"Congo - Brazzaville":                    "CG",
+	"Congo - Kinshasa":                       "CD",
+	"Cook Islands":                           "CK",
+	"Costa Rica":                             "CR",
+	"Cote d'Ivoire":                          "CI",
+	"Croatia":                                "HR",
+	"Cuba":                                   "CU",
+	"Curaçao":                                "CW",
+	"Cyprus":                                 "CY",
+	"Czech Republic":                         "CZ",
+	"Czechia":                                "CZ",
+	"Côte d’Ivoire":                          "CI",
+	"Democratic Republic of the Congo":       "CD",
+	"Denmark":                                "DK",
+	"Djibouti":                               "DJ",
+	"Dominica":                               "DM",
+	"Dominican Republic":                     "DO",
+	"East Timor":                             "TL",
+	"Ecuador":                                "EC",
+	"Egypt":                                  "EG",
+	"El Salvador":                            "SV",
+	"Equatorial Guinea":                      "GQ",
+	"Eritrea":                                "ER",
+	"Estonia":                                "EE",
+	"Eswatini":                               "SZ",
+	"Ethiopia":                               "ET",
+	"Falkland Islands":                       "FK",
+	"Faroe Islands":                          "FO",
+	"Fiji":                                   "FJ",
+	"Finland":                                "FI",
+	"France":                                 "FR",
+	"French Guiana":                          "GF",
+	"French Polynesia":                       "PF",
+	"French Southern Territories":            "TF",
+	"Gabon":                                  "GA",
+	"Gambia":                                 "GM",
+	"Georgia":                                "GE",
+	"Germany":                                "DE",
+	"Ghana":                                  "GH",
+	"Gibraltar":                              "GI",
+	"Greece":                                 "GR",
+	"Greenland":                              "GL",
+	"Grenada":                                "GD",
+	"Guadeloupe":                             "GP",
+	"Guam":                                   "GU",
+	"Guatemala":                              "GT",
+	"Guernsey":                               "GG",
+	"Guinea":                                 "GN",
+	"Guinea-Bissau":                          "GW",
+	"Guyana":                                 "GY",
+	"Haiti":                                  "HT",
+	"Heard & McDonald Islands":               "HM",
+	"Holy See":                               "VA",
+	"Honduras":                               "HN",
+	"Hong Kong":                              "HK",
+	"Hong Kong SAR China":                    "HK",
+	"Hungary":                                "HU",
+	"Iceland":                                "IS",
+	"India":                                  "IN",
+	"Indonesia":                              "ID",
+	"Iran":                                   "IR",
+	"Iran, Islamic Republic of":              "IR",
+	"Iraq":                                   "IQ",
+	"Ireland":                                "IE",
+	"Isle of Man":                            "IM",
+	"Israel":                                 "IL",
+	"Italy":                                  "IT",
+	"Ivory Coast":                            "CI",
+	"Jamaica":                                "JM",
+	"Japan":                                  "JP",
+	"Jersey":                                 "JE",
+	"Jordan":                                 "JO",
+	"Kazakhstan":                             "KZ",
+	"Kenya":                                  "KE",
+	"Kiribati":                               "KI",
+	"Korea":                                  "KR",
+	"Kosovo":                                 "XK",
+	"Kuwait":                                 "KW",
+	"Kyrgyzstan":                             "KG",
+	"Lao People's Democratic Republic":       "LA",
+	"Laos":                                   "LA",
+	"Latvia":                                 "LV",
+	"Lebanon":                                "LB",
+	"Lesotho":                                "LS",
+	"Liberia":                                "LR",
+	"Libya":                                  "LY",
+	"Liechtenstein":                          "LI",
+	"Lithuania":                              "LT",
+	"Luxembourg":                             "LU",
+	"Macao":                                  "MO",
+	"Macau":                                  "MO",
+	"Macau SAR China":                        "MO",
+	"Macedonia":                              "MK",
+	"Madagascar":                             "MG",
+	"Malawi":                                 "MW",
+	"Malaysia":                               "MY",
+	"Maldives":                               "MV",
+	"Mali":                                   "ML",
+	"Malta":                                  "MT",
+	"Marshall Islands":                       "MH",
+	"Martinique":                             "MQ",
+	"Mauritania":                             "MR",
+	"Mauritius":                              "MU",
+	"Mayotte":                                "YT",
+	"Mexico":                                 "MX",
+	"Micronesia":                             "FM",
+	"Moldova":                                "MD",
+	"Moldova, Republic of":                   "MD",
+	"Monaco":                                 "MC",
+	"Mongolia":                               "MN",
+	"Montenegro":                             "ME",
+	"Montserrat":                             "MS",
+	"Morocco":                                "MA",
+	"Mozambique":                             "MZ",
+	"Myanmar":                                "MM",
+	"Myanmar (Burma)":                        "MM",
+	"Namibia":                                "NA",
+	"Nauru":                                  "NR",
+	"Nepal":                                  "NP",
+	"Netherlands":                            "NL",
+	"New Caledonia":                          "NC",
+	"New Zealand":                            "NZ",
+	"Nicaragua":                              "NI",
+	"Niger":                                  "NE",
+	"Nigeria":                                "NG",
+	"Niue":                                   "NU",
+	"Norfolk Island":                         "NF",
+	"North Korea":                            "KP",
+	"North Macedonia":                        "MK",
+	"Northern Mariana Islands":               "MP",
+	"Norway":                                 "NO",
+	"Oman":                                   "OM",
+	"Pakistan":                               "PK",
+	"Palau":                                  "PW",
+	"Palestine":                              "PS",
+	"Palestinian Territories":                "PS",
+	"Panama":                                 "PA",
+	"Papua New Guinea":                       "PG",
+	"Paraguay":                               "PY",
+	"Peru":                                   "PE",
+	"Philippines":                            "PH",
+	"Pitcairn Islands":                       "PN",
+	"Poland":                                 "PL",
+	"Portugal":                               "PT",
+	"Puerto Rico":                            "PR",
+	"Qatar":                                  "QA",
+	"Republic of Korea":                      "KR",
+	"Republic of the Congo":                  "CG",
+	"Romania":                                "RO",
+	"Russia":                                 "RU",
+	"Russian Federation":                     "RU",
+	"Rwanda":                                 "RW",
+	"Réunion":                                "RE",
+	"Saint Kitts and Nevis":                  "KN",
+	"Saint Lucia":                            "LC",
+	"Saint Vincent and the Grenadines":       "VC",
+	"Samoa":                                  "WS",
+	"San Marino":                             "SM",
+	"Sao Tome and Principe":                  "ST",
+	"Saudi Arabia":                           "SA",
+	"Senegal":                                "SN",
+	"Serbia":                                 "RS",
+	"Seychelles":                             "SC",
+	"Sierra Leone":                           "SL",
+	"Singapore":                              "SG",
+	"Sint Maarten":                           "SX",
+	"Slovakia":                               "SK",
+	"Slovenia":                               "SI",
+	"Solomon Islands":                        "SB",
+	"Somalia":                                "SO",
+	"South Africa":                           "ZA",
+	"South Georgia & South Sandwich Islands": "GS",
+	"South Korea":                            "KR",
+	"South Sudan":                            "SS",
+	"Spain":                                  "ES",
+	"Sri Lanka":                              "LK",
+	"St. Barthélemy":                         "BL",
+	"St. Helena":                             "SH",
+	"St. Kitts & Nevis":                      "KN",
+	"St. Lucia":                              "LC",
+	"St. Martin":                             "MF",
+	"St. Pierre & Miquelon":                  "PM",
+	"St. Vincent & Grenadines":               "VC",
+	"Sudan":                                  "SD",
+	"Suriname":                               "SR",
+	"Svalbard & Jan Mayen":                   "SJ",
+	"Swaziland":                              "SZ",
+	"Sweden":                                 "SE",
+	"Switzerland":                            "CH",
+	"Syria":                                  "SY",
+	"Syrian Arab Republic":                   "SY",
+	"São Tomé & Príncipe":                    "ST",
+	"Taiwan":                                 "TW",
+	"Taiwan, Province of China":              "TW",
+	"Tajikistan":                             "TJ",
+	"Tanzania":                               "TZ",
+	"Tanzania, United Republic of":           "TZ",
+	"Thailand":                               "TH",
+	"Timor-Leste":                            "TL",
+	"Togo":                                   "TG",
+	"Tokelau":                                "TK",
+	"Tonga":                                  "TO",
+	"Trinidad & Tobago":                      "TT",
+	"Trinidad and Tobago":                    "TT",
+	"Tunisia":                                "TN",
+	"Turkey":                                 "TR",
+	"Turkmenistan":                           "TM",
+	"Turks & Caicos Islands":                 "TC",
+	"Turks and Caicos Islands":               "TC",
+	"Tuvalu":                                 "TV",
+	"Türkiye":                                "TR",
+	"U.S. Outlying Islands":                  "UM",
+	"U.S. Virgin Islands":                    "VI",
+	"Uganda":                                 "UG",
+	"Ukraine":                                "UA",
+	"United Arab Emirates":                   "AE",
+	"United Kingdom":                         "GB",
+	"United States":                          "US",
+	"United States of America":               "US",
+	"Uruguay":                                "UY",
+	"Uzbekistan":                             "UZ",
+	"Vanuatu":                                "VU",
+	"Vatican City":                           "VA",
+	"Venezuela":                              "VE",
+	"Viet Nam":                               "VN",
+	"Vietnam":                                "VN",
+	"Wallis & Futuna":                        "WF",
+	"Western Sahara":                         "EH",
+	"Yemen":                                  "YE",
+	"Zambia":                                 "ZM",
+	"Zimbabwe":                               "ZW",
+	"Åland Islands":                          "AX",
+}
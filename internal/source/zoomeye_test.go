@@ -0,0 +1,99 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yoshino-s/derperer/internal/derperer"
+)
+
+func TestZoomEyeCountry(t *testing.T) {
+	for _, tt := range []struct {
+		country string
+		want    string
+	}{
+		{"China", "CN"},
+		{"United States", "US"},
+		{"United Kingdom", "GB"},
+		{"Hong Kong", "HK"},
+		{"South Korea", "KR"},
+		{"Russia", "RU"},
+		{"Unknown", "Unknown"},
+		{"", ""},
+	} {
+		if got := zoomeyeCountry(tt.country); got != tt.want {
+			t.Errorf("zoomeyeCountry(%q) = %q, want %q", tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestZoomEyeFetchPages(t *testing.T) {
+	assets := []zoomeyeAsset{
+		{IP: "192.0.2.1", Port: 443, Service: "https", Country: "Japan", Province: "Tokyo", City: "Tokyo", ISP: "Example", ASN: 64496},
+		{IP: "192.0.2.2", Port: 80, Service: "http", Country: "Japan"},
+		{IP: "192.0.2.3", Port: 8443, Service: "https", Country: "Hong Kong", City: "Hong Kong"},
+	}
+	var pages []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Qbase64  string `json:"qbase64"`
+			Page     int    `json:"page"`
+			PageSize int    `json:"pagesize"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		query, _ := base64.StdEncoding.DecodeString(req.Qbase64)
+		if r.Method != http.MethodPost || r.URL.Path != "/v2/search" || r.Header.Get("API-KEY") != "secret" || string(query) != ZOOMEYE_QUERY {
+			json.NewEncoder(w).Encode(zoomeyeSearchResult{Code: 40001, Message: "bad request"})
+			return
+		}
+		pages = append(pages, req.Page)
+		res := zoomeyeSearchResult{Code: zoomeyeSuccess, Total: 2*zoomeyePageSize + len(assets)}
+		// full pages of the first asset, then all of them
+		if req.Page <= 2 {
+			for range req.PageSize {
+				res.Data = append(res.Data, assets[0])
+			}
+		} else {
+			res.Data = assets
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	s := NewZoomEye()
+	s.config.Endpoint = ts.URL
+	s.config.Key = "secret"
+	candidates, err := s.Fetch(context.Background(), derperer.FetchOptions{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Errorf("requested pages %v, want [1 2 3]", pages)
+	}
+	if len(candidates) != 2*zoomeyePageSize+2 {
+		t.Fatalf("%d candidates, want %d", len(candidates), 2*zoomeyePageSize+2)
+	}
+	first := candidates[0]
+	if first.Host != "192.0.2.1" || first.Port != 443 || first.IP.String() != "192.0.2.1" || first.Country != "JP" || first.Region != "Tokyo" || first.City != "Tokyo" || first.Org != "Example" || first.ASN != "AS64496" {
+		t.Errorf("unexpected candidate %+v", first)
+	}
+	// the asset without TLS is skipped
+	last := candidates[len(candidates)-1]
+	if last.Host != "192.0.2.3" || last.Port != 8443 || last.Country != "HK" {
+		t.Errorf("unexpected candidate %+v", last)
+	}
+
+	// the limit stops paging
+	pages = nil
+	candidates, err = s.Fetch(context.Background(), derperer.FetchOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || len(candidates) != 10 {
+		t.Errorf("requested pages %v for %d candidates, want [1] for 10", pages, len(candidates))
+	}
+}
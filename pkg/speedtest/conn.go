@@ -0,0 +1,283 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// ConnTiming is the time spent in each phase of connecting to a DERP server,
+// like net/http/httptrace. Phases which didn't run are zero, a failed phase
+// holds the time until it failed.
+type ConnTiming struct {
+	DNS        time.Duration `json:"dns,omitempty"`
+	TCP        time.Duration `json:"tcp,omitempty"`
+	TLS        time.Duration `json:"tls,omitempty"`
+	Upgrade    time.Duration `json:"upgrade,omitempty"`
+	ServerInfo time.Duration `json:"server_info,omitempty"`
+}
+
+func (t ConnTiming) Total() time.Duration {
+	return t.DNS + t.TCP + t.TLS + t.Upgrade + t.ServerInfo
+}
+
+func (t ConnTiming) String() string {
+	return fmt.Sprintf("dns %s, tcp %s, tls %s, upgrade %s, server info %s", t.DNS, t.TCP, t.TLS, t.Upgrade, t.ServerInfo)
+}
+
+// Timing holds the connection timings of both clients of a check, Sender
+// connects first.
+type Timing struct {
+	Sender   ConnTiming `json:"sender,omitzero"`
+	Receiver ConnTiming `json:"receiver,omitzero"`
+}
+
+// Phases of connecting, as reported by TimeoutError.Op.
+const (
+	phaseResolve    = "resolve"
+	phaseDial       = "dial"
+	phaseTLS        = "tls handshake"
+	phaseUpgrade    = "upgrade"
+	phaseServerInfo = "server info"
+)
+
+// derpConn is a DERP client of a region and the ServerInfoMessage it got.
+type derpConn struct {
+	*derphttp.Client
+	info derp.ServerInfoMessage
+}
+
+func newDerpConn(region *tailcfg.DERPRegion, priv key.NodePrivate, logf logger.Logf) *derpConn {
+	return &derpConn{
+		Client: derphttp.NewRegionClient(priv, logf, netmon.NewStatic(), func() *tailcfg.DERPRegion {
+			return region
+		}),
+	}
+}
+
+// connect connects to the first reachable node of the region and waits for
+// the ServerInfoMessage. Resolving, the TCP connect and the TLS handshake
+// must finish within connectTimeout, the HTTP upgrade, the DERP handshake and
+// the ServerInfoMessage within handshakeTimeout after that. The client is
+// closed if a timeout expires or ctx is done, since derphttp can't abort
+// Recv otherwise.
+func (c *derpConn) connect(ctx context.Context, connectTimeout, handshakeTimeout time.Duration, timing *ConnTiming) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+
+	trace := newConnTrace()
+	var handshakeTimer *time.Timer
+	connectTimer := time.AfterFunc(connectTimeout, func() {
+		cancel(&TimeoutError{Op: trace.phase(), Err: context.DeadlineExceeded})
+	})
+	defer connectTimer.Stop()
+	trace.onSecured = func() {
+		if connectTimer.Stop() {
+			handshakeTimer = time.AfterFunc(handshakeTimeout, func() {
+				cancel(&TimeoutError{Op: trace.phase(), Err: context.DeadlineExceeded})
+			})
+		}
+	}
+	c.TLSConfig = &tls.Config{KeyLogWriter: trace}
+
+	err := c.Connect(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+	*timing = trace.timing()
+	if err != nil {
+		return trace.error(ctx, err)
+	}
+	trace.serverInfo()
+
+	start := time.Now()
+	m, err := c.Recv()
+	timing.ServerInfo = time.Since(start)
+	if handshakeTimer != nil {
+		handshakeTimer.Stop()
+	}
+	if err != nil {
+		return trace.error(ctx, err)
+	}
+	info, ok := m.(derp.ServerInfoMessage)
+	if !ok {
+		return &ProtocolError{Msg: fmt.Sprintf("got %T, want derp.ServerInfoMessage", m)}
+	}
+	c.info = info
+
+	if !stop() {
+		return trace.error(ctx, ctx.Err())
+	}
+	return nil
+}
+
+// connTrace follows derphttp through the phases of connecting, which it runs
+// in a single call. Resolving and the TCP connect are traced like in
+// net/http. crypto/tls has no hook for the end of the handshake, it is marked
+// by the TLS key log instead, which is written once the server certificate
+// was verified and the traffic keys are set up. The secrets are discarded.
+type connTrace struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dns          time.Duration
+	connectStart map[string]time.Time
+	connected    time.Time
+	tcp          time.Duration
+	dialErr      error
+	secured      time.Time
+	connectedAll bool
+
+	onSecured func()
+}
+
+func newConnTrace() *connTrace {
+	return &connTrace{start: time.Now(), connectStart: map[string]time.Time{}}
+}
+
+func (t *connTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dns = time.Since(t.dnsStart)
+			if info.Err != nil && t.dialErr == nil {
+				t.dialErr = info.Err
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connectStart[network+" "+addr] = time.Now()
+		},
+		// derphttp races IPv4 and IPv6 and goes on with the first
+		// connection
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err != nil {
+				if t.dialErr == nil {
+					t.dialErr = err
+				}
+				return
+			}
+			if t.connected.IsZero() {
+				t.connected = time.Now()
+				t.tcp = t.connected.Sub(t.connectStart[network+" "+addr])
+			}
+		},
+	}
+}
+
+// Write receives the TLS key log, one line per secret.
+func (t *connTrace) Write(line []byte) (int, error) {
+	label, _, _ := bytes.Cut(line, []byte(" "))
+	// the application traffic secret of TLS 1.3, the master secret of
+	// TLS 1.2
+	if string(label) != "CLIENT_TRAFFIC_SECRET_0" && string(label) != "CLIENT_RANDOM" {
+		return len(line), nil
+	}
+	t.mu.Lock()
+	first := t.secured.IsZero()
+	if first {
+		t.secured = time.Now()
+	}
+	t.mu.Unlock()
+	if first && t.onSecured != nil {
+		t.onSecured()
+	}
+	return len(line), nil
+}
+
+// serverInfo notes that derphttp connected and the ServerInfoMessage is
+// awaited.
+func (t *connTrace) serverInfo() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connectedAll = true
+}
+
+// phase returns the phase the connection is in.
+func (t *connTrace) phase() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.connectedAll:
+		return phaseServerInfo
+	case !t.secured.IsZero():
+		return phaseUpgrade
+	case !t.connected.IsZero():
+		return phaseTLS
+	case t.dialErr != nil && len(t.connectStart) == 0:
+		return phaseResolve
+	default:
+		return phaseDial
+	}
+}
+
+// timing returns the timing of derphttp's connect, which just returned.
+func (t *connTrace) timing() ConnTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	timing := ConnTiming{DNS: t.dns, TCP: t.tcp}
+	switch {
+	case !t.secured.IsZero():
+		timing.TLS = t.secured.Sub(t.connected)
+		timing.Upgrade = now.Sub(t.secured)
+	case !t.connected.IsZero():
+		timing.TLS = now.Sub(t.connected)
+	default:
+		timing.TCP = max(now.Sub(t.start)-t.dns, 0)
+	}
+	return timing
+}
+
+// error wraps err of the current phase in a typed error. derphttp formats
+// the errors of connecting with %v, so they are classified by the phase
+// they happened in, dial errors are taken from the trace.
+func (t *connTrace) error(ctx context.Context, err error) error {
+	phase := t.phase()
+	if ctx.Err() != nil {
+		var timeout *TimeoutError
+		switch cause := context.Cause(ctx); {
+		case errors.As(cause, &timeout):
+			return timeout
+		case errors.Is(cause, context.DeadlineExceeded):
+			return &TimeoutError{Op: phase, Err: cause}
+		default:
+			// cancellation says nothing about the server
+			return errors.Errorf("%s: %w", phase, cause)
+		}
+	}
+	switch phase {
+	case phaseResolve, phaseDial:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.dialErr != nil {
+			return &DialError{Err: t.dialErr}
+		}
+		return &DialError{Err: err}
+	case phaseTLS:
+		return &TLSError{Err: err}
+	case phaseUpgrade:
+		return &HandshakeError{Err: err}
+	default:
+		return classifyConnError(phase, err)
+	}
+}
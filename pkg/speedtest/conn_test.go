@@ -0,0 +1,197 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// blackHoleNode returns a node which accepts TCP connections but never
+// completes the TLS handshake.
+func blackHoleNode(t *testing.T) *tailcfg.DERPNode {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return &tailcfg.DERPNode{
+		Name:             "1b",
+		RegionID:         1,
+		HostName:         "127.0.0.1",
+		IPv4:             "127.0.0.1",
+		IPv6:             "none",
+		DERPPort:         ln.Addr().(*net.TCPAddr).Port,
+		InsecureForTests: true,
+	}
+}
+
+// closedNode returns a node which refuses TCP connections.
+func closedNode(t *testing.T) *tailcfg.DERPNode {
+	t.Helper()
+	node := blackHoleNode(t)
+	node.Name = "1c"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.DERPPort = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return node
+}
+
+func connect(ctx context.Context, t *testing.T, region *tailcfg.DERPRegion, connectTimeout time.Duration) (*derpConn, error) {
+	c := newDerpConn(region, key.NewNode(), t.Logf)
+	var timing ConnTiming
+	if err := c.connect(ctx, connectTimeout, time.Second, &timing); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func TestConnectFailsOverPerNode(t *testing.T) {
+	node, _ := newDerpNode(t)
+	region := &tailcfg.DERPRegion{RegionID: 1, Nodes: []*tailcfg.DERPNode{closedNode(t), node}}
+
+	// the refused node is skipped within the connect timeout
+	c, err := connect(context.Background(), t, region, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestConnectErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		node  *tailcfg.DERPNode
+		class ErrorClass
+		op    string
+	}{
+		{"refused", closedNode(t), ErrorClassDial, ""},
+		{"no tls", blackHoleNode(t), ErrorClassTimeout, phaseTLS},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			region := &tailcfg.DERPRegion{RegionID: 1, Nodes: []*tailcfg.DERPNode{tt.node}}
+			_, err := connect(context.Background(), t, region, 200*time.Millisecond)
+			if ClassOf(err) != tt.class {
+				t.Fatalf("got error %v of class %q, want %q", err, ClassOf(err), tt.class)
+			}
+			var timeout *TimeoutError
+			if errors.As(err, &timeout) && timeout.Op != tt.op {
+				t.Errorf("timeout in %q, want %q", timeout.Op, tt.op)
+			}
+		})
+	}
+}
+
+func TestCheckDerpStopsOnCancel(t *testing.T) {
+	region := &tailcfg.DERPRegion{RegionID: 1, Nodes: []*tailcfg.DERPNode{blackHoleNode(t)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := New().CheckDerp(ctx, region, CheckOptions{ConnectTimeout: time.Minute})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %s, want right after the cancel", elapsed)
+	}
+}
+
+func TestConnectCertName(t *testing.T) {
+	node, cert := newDerpNode(t)
+	node.InsecureForTests = false
+	// the certificate of httptest is valid for example.com
+	node.HostName = "example.com"
+
+	for _, tt := range []struct {
+		certName string
+		ok       bool
+	}{
+		{"", false},
+		{fmt.Sprintf("sha256-raw:%x", sha256.Sum256(cert.Raw)), true},
+		{fmt.Sprintf("sha256-raw:%x", sha256.Sum256(nil)), false},
+	} {
+		node.CertName = tt.certName
+		region := &tailcfg.DERPRegion{RegionID: 1, Nodes: []*tailcfg.DERPNode{node}}
+		c, err := connect(context.Background(), t, region, time.Second)
+		if err == nil {
+			c.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("CertName %q: got error %v, want success %t", tt.certName, err, tt.ok)
+		}
+		if err != nil && ClassOf(err) != ErrorClassTLS {
+			t.Errorf("CertName %q: got error %v of class %q, want %q", tt.certName, err, ClassOf(err), ErrorClassTLS)
+		}
+	}
+}
+
+func TestConnectTiming(t *testing.T) {
+	node, _ := newDerpNode(t)
+	// resolve the name rather than dialing the IP
+	node.HostName = "localhost"
+	node.IPv4 = ""
+	region := &tailcfg.DERPRegion{RegionID: 1, Nodes: []*tailcfg.DERPNode{node}}
+
+	// a trace of the test runs alongside the one of connect
+	var events []string
+	var mu sync.Mutex
+	event := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+	}
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { event("dns start") },
+		DNSDone:      func(httptrace.DNSDoneInfo) { event("dns done") },
+		ConnectStart: func(string, string) { event("connect start") },
+		ConnectDone:  func(string, string, error) { event("connect done") },
+	})
+
+	c := newDerpConn(region, key.NewNode(), t.Logf)
+	defer c.Close()
+	var timing ConnTiming
+	start := time.Now()
+	if err := c.connect(ctx, time.Second, time.Second, &timing); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if want := []string{"dns start", "dns done", "connect start", "connect done"}; !slices.Equal(events, want) {
+		t.Errorf("traced %q, want %q", events, want)
+	}
+
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dns", timing.DNS},
+		{"tcp", timing.TCP},
+		{"tls", timing.TLS},
+		{"upgrade", timing.Upgrade},
+		{"server info", timing.ServerInfo},
+	} {
+		if phase.d <= 0 {
+			t.Errorf("%s took %s, want > 0", phase.name, phase.d)
+		}
+	}
+	// the phases follow each other, so they add up to at most the time
+	// connect took
+	if timing.Total() > elapsed {
+		t.Errorf("phases took %s in total, longer than connecting (%s)", timing.Total(), elapsed)
+	}
+}
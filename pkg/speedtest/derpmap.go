@@ -0,0 +1,36 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-errors/errors"
+	"tailscale.com/tailcfg"
+)
+
+// FetchDERPMap downloads and decodes the DERP map served at url.
+func FetchDERPMap(ctx context.Context, url string) (*tailcfg.DERPMap, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.Errorf("create derp map request: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Errorf("fetch derp map failed: %w", err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Errorf("fetch derp map failed: %w", err)
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.Errorf("fetch derp map: %v: %s", res.Status, b)
+	}
+	var dmap tailcfg.DERPMap
+	if err = json.Unmarshal(b, &dmap); err != nil {
+		return nil, errors.Errorf("fetch DERP map: %w", err)
+	}
+	return &dmap, nil
+}
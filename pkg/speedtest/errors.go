@@ -0,0 +1,133 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/go-errors/errors"
+)
+
+type ErrorClass string
+
+const (
+	ErrorClassDial      ErrorClass = "dial"
+	ErrorClassTLS       ErrorClass = "tls"
+	ErrorClassHandshake ErrorClass = "handshake"
+	ErrorClassTimeout   ErrorClass = "timeout"
+	ErrorClassProtocol  ErrorClass = "protocol"
+	ErrorClassShortRead ErrorClass = "short_read"
+	ErrorClassUnknown   ErrorClass = "unknown"
+)
+
+// ClassifiedError is implemented by the typed errors returned by CheckDerp.
+type ClassifiedError interface {
+	error
+	Class() ErrorClass
+}
+
+// ClassOf returns the class of the first ClassifiedError in err's chain.
+func ClassOf(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	var classified ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class()
+	}
+	return ErrorClassUnknown
+}
+
+// DialError reports a failure to resolve or connect to the DERP server.
+type DialError struct {
+	Err error
+}
+
+func (e *DialError) Error() string     { return fmt.Sprintf("dial: %v", e.Err) }
+func (e *DialError) Unwrap() error     { return e.Err }
+func (e *DialError) Class() ErrorClass { return ErrorClassDial }
+
+// TLSError reports a failed TLS handshake or certificate verification.
+type TLSError struct {
+	Err error
+}
+
+func (e *TLSError) Error() string     { return fmt.Sprintf("tls: %v", e.Err) }
+func (e *TLSError) Unwrap() error     { return e.Err }
+func (e *TLSError) Class() ErrorClass { return ErrorClassTLS }
+
+// HandshakeError reports the server rejecting the DERP upgrade or handshake.
+type HandshakeError struct {
+	Err error
+}
+
+func (e *HandshakeError) Error() string     { return fmt.Sprintf("handshake rejected: %v", e.Err) }
+func (e *HandshakeError) Unwrap() error     { return e.Err }
+func (e *HandshakeError) Class() ErrorClass { return ErrorClassHandshake }
+
+// TimeoutError reports an operation which didn't finish in time.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string     { return fmt.Sprintf("%s timeout: %v", e.Op, e.Err) }
+func (e *TimeoutError) Unwrap() error     { return e.Err }
+func (e *TimeoutError) Class() ErrorClass { return ErrorClassTimeout }
+
+// ProtocolError reports a message the DERP protocol doesn't allow here.
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string     { return fmt.Sprintf("protocol violation: %s", e.Msg) }
+func (e *ProtocolError) Class() ErrorClass { return ErrorClassProtocol }
+
+// ShortReadError reports a packet smaller than the one sent.
+type ShortReadError struct {
+	Got  int
+	Want int
+}
+
+func (e *ShortReadError) Error() string {
+	return fmt.Sprintf("short read: got %d bytes, want %d bytes", e.Got, e.Want)
+}
+func (e *ShortReadError) Class() ErrorClass { return ErrorClassShortRead }
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// classifyConnError wraps an error of the derp client in a typed error,
+// going by the types in its chain. Cancellation is passed through, since it
+// says nothing about the server.
+func classifyConnError(op string, err error) error {
+	var (
+		opErr          *net.OpError
+		dnsErr         *net.DNSError
+		verifyErr      *tls.CertificateVerificationError
+		alertErr       tls.AlertError
+		recordErr      tls.RecordHeaderError
+		authorityErr   x509.UnknownAuthorityError
+		hostnameErr    x509.HostnameError
+		certInvalidErr x509.CertificateInvalidError
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled):
+		return errors.Errorf("%s: %w", op, err)
+	case isTimeout(err):
+		return &TimeoutError{Op: op, Err: err}
+	case errors.As(err, &verifyErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &certInvalidErr):
+		return &TLSError{Err: err}
+	case errors.As(err, &dnsErr), errors.As(err, &opErr) && opErr.Op == "dial":
+		return &DialError{Err: err}
+	}
+	return errors.Errorf("%s: %w", op, err)
+}
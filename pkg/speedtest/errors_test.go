@@ -0,0 +1,48 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-errors/errors"
+)
+
+func TestClassifyConnError(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		err   error
+		class ErrorClass
+	}{
+		{"nil", nil, ""},
+		{"canceled", fmt.Errorf("recv: %w", context.Canceled), ErrorClassUnknown},
+		{"deadline", context.DeadlineExceeded, ErrorClassTimeout},
+		{"read deadline", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ErrorClassTimeout},
+		{"dns timeout", &net.DNSError{Name: "derp.example.com", IsTimeout: true}, ErrorClassTimeout},
+		{"no such host", &net.DNSError{Name: "derp.example.com", Err: "no such host", IsNotFound: true}, ErrorClassDial},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorClassDial},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, ErrorClassUnknown},
+		{"unknown authority", fmt.Errorf("connect: %w", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), ErrorClassTLS},
+		{"hostname", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "derp.example.com"}, ErrorClassTLS},
+		{"expired", x509.CertificateInvalidError{Reason: x509.Expired}, ErrorClassTLS},
+		{"alert", tls.AlertError(40), ErrorClassTLS},
+		{"not tls", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ErrorClassTLS},
+		{"eof", io.EOF, ErrorClassUnknown},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyConnError("receive packet", tt.err)
+			if class := ClassOf(err); class != tt.class {
+				t.Errorf("got %v of class %q, want %q", err, class, tt.class)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("%v doesn't wrap %v", err, tt.err)
+			}
+		})
+	}
+}
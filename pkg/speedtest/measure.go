@@ -1,94 +1,241 @@
 package speedtest
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/go-errors/errors"
-	"github.com/sourcegraph/conc"
+	"github.com/sourcegraph/conc/pool"
 	"tailscale.com/derp"
-	"tailscale.com/derp/derphttp"
-	"tailscale.com/types/key"
 )
 
+// packet header: send timestamp in unix nanoseconds, then sequence number
+const (
+	timestampOffset = 0
+	sequenceOffset  = 8
+	headerSize      = 16
+)
+
+const (
+	// floodPacketSize is the size of the packets measuring the bandwidth.
+	floodPacketSize = 64 * 1024
+	// probePacketSize is the size of the packets measuring latency and loss,
+	// about the size of interactive traffic like SSH.
+	probePacketSize = 128
+	probeInterval   = 20 * time.Millisecond
+)
+
+type LatencyStats struct {
+	Min    time.Duration `json:"min"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+	Jitter time.Duration `json:"jitter"`
+}
+
 type SpeedTestResult struct {
 	TotalBytesSent Unit
 	Bps            Unit
 	Latency        time.Duration
+	LatencyStats   LatencyStats
+
+	// PacketsReceived and PacketsLost count the latency probes.
+	PacketsReceived int
+	PacketsLost     int
+	// PacketLoss is the ratio of lost probes, between 0 and 1.
+	PacketLoss float64
+
+	Timing Timing
 }
 
-func (s *SpeedTestService) measure(c1, c2 *derphttp.Client, c2DstKey key.NodePublic, duration time.Duration) (*SpeedTestResult, error) {
-	packetSize := 64 * 1024
-	var packetCount int
-	var totalLatency time.Duration
+// newLatencyStats computes the distribution of samples, in receive order.
+// Jitter is the mean difference between consecutive samples.
+func newLatencyStats(samples []time.Duration) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+	var jitter time.Duration
+	for i := 1; i < len(samples); i++ {
+		d := samples[i] - samples[i-1]
+		jitter += max(d, -d)
+	}
+	if len(samples) > 1 {
+		jitter /= time.Duration(len(samples) - 1)
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	return LatencyStats{
+		Min:    sorted[0],
+		P50:    percentile(50),
+		P90:    percentile(90),
+		P99:    percentile(99),
+		Max:    sorted[len(sorted)-1],
+		Jitter: jitter,
+	}
+}
+
+// measure floods c2 with packets from c1 for duration to measure the
+// bandwidth. Latency and loss are measured on small probes paced at
+// probeInterval in the opposite direction, so they are not queued behind the
+// flood and dropped with it. Both connections are closed when the duration is
+// over, which ends the blocking Send and Recv.
+func (s *SpeedTestService) measure(ctx context.Context, c1, c2 *derpConn, duration time.Duration) (*SpeedTestResult, error) {
 	res := &SpeedTestResult{}
 
-	wg := conc.NewWaitGroup()
-	fmt.Printf("Start sending packets for %s...\n", duration)
+	measureCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	stop := context.AfterFunc(measureCtx, func() {
+		c1.Close()
+		c2.Close()
+	})
+	defer stop()
 
-	wg.Go(func() {
-		t := time.After(duration)
-		randBuf := make([]byte, packetSize)
-		if _, err := rand.Read(randBuf); err != nil {
-			panic(err)
-		}
-		for {
-			select {
-			case <-t:
-				return
-			default:
-				// construct packet
-				// marshal the timestamp into first 8 bytes
-				binary.LittleEndian.PutUint64(randBuf, uint64(time.Now().UnixNano()))
-
-				if err := c1.Send(c2DstKey, randBuf); err != nil {
-					panic(err)
-				}
-			}
+	// done reports whether err was caused by the end of the measurement,
+	// rather than by the server.
+	done := func(err error) (bool, error) {
+		if ctx.Err() != nil {
+			return true, errors.Errorf("measure: %w", ctx.Err())
 		}
+		return measureCtx.Err() != nil, nil
+	}
+
+	p := pool.New().WithErrors().WithFirstError()
+	s.Logger.Sugar().Debugf("start sending packets for %s", duration)
+
+	p.Go(func() error {
+		return send(measureCtx, c1, c2, floodPacketSize, 0, done)
+	})
+	p.Go(func() error {
+		return send(measureCtx, c2, c1, probePacketSize, probeInterval, done)
 	})
 
-	wg.Go(func() {
-		t := time.After(duration)
+	p.Go(func() error {
+		var packetCount int
 		start := time.Now()
-		for {
-			select {
-			case <-t:
-				elapsed := time.Since(start)
-				res.Bps = Unit{float64(packetCount*packetSize*8) / elapsed.Seconds(), "bps"}
-				res.TotalBytesSent = Unit{float64(packetCount * packetSize), "bytes"}
-				res.Latency = totalLatency / time.Duration(packetCount) / 2
-				return
-			default:
-				pkt, err := c2.Recv()
-				if err != nil {
-					panic(errors.Errorf("recv packet: %w", err))
-				}
-				p, ok := pkt.(derp.ReceivedPacket)
-				if !ok {
-					panic(errors.Errorf("got %T, want ReceivedPacket", p))
-				}
-				// unmarshal the timestamp from first 8 bytes
-				timestamp := int64(binary.LittleEndian.Uint64(p.Data))
-
-				totalLatency += time.Since(time.Unix(0, timestamp))
-
-				if len(p.Data) != packetSize {
-					panic(errors.Errorf("got %d bytes, want %d bytes", len(p.Data), packetSize))
-				}
-
-				packetCount++
-
-				s.Logger.Sugar().Debugf("packetCount: %d, latency: %s", packetCount, time.Since(time.Unix(0, timestamp)))
-			}
+		err := recv(measureCtx, c2, floodPacketSize, done, func(timestamp int64, seq uint64) {
+			packetCount++
+		})
+		if err != nil {
+			return err
 		}
+
+		elapsed := time.Since(start)
+		if packetCount == 0 {
+			return &TimeoutError{Op: "receive packet", Err: errors.Errorf("no packet received in %s", duration)}
+		}
+		res.Bps = Unit{float64(packetCount*floodPacketSize*8) / elapsed.Seconds(), "bps"}
+		res.TotalBytesSent = Unit{float64(packetCount * floodPacketSize), "bytes"}
+		return nil
+	})
+
+	p.Go(func() error {
+		var packetCount int
+		var totalLatency time.Duration
+		var latencies []time.Duration
+		var maxSeq uint64
+		err := recv(measureCtx, c1, probePacketSize, done, func(timestamp int64, seq uint64) {
+			latency := time.Since(time.Unix(0, timestamp)) / 2
+			totalLatency += latency
+			latencies = append(latencies, latency)
+			maxSeq = max(maxSeq, seq)
+			packetCount++
+
+			s.Logger.Sugar().Debugf("probeCount: %d, seq: %d, latency: %s", packetCount, seq, latency)
+		})
+		if err != nil {
+			return err
+		}
+
+		if packetCount == 0 {
+			return &TimeoutError{Op: "receive probe", Err: errors.Errorf("no probe received in %s", duration)}
+		}
+		res.Latency = totalLatency / time.Duration(packetCount)
+		res.LatencyStats = newLatencyStats(latencies)
+		// probes sent after the last received one may still be in
+		// flight, so loss is only counted up to the highest sequence
+		res.PacketsReceived = packetCount
+		res.PacketsLost = max(int(maxSeq)+1-packetCount, 0)
+		res.PacketLoss = float64(res.PacketsLost) / float64(res.PacketsLost+packetCount)
+		return nil
 	})
 
-	if err := wg.WaitAndRecover(); err != nil {
-		return nil, err.AsError()
+	if err := p.Wait(); err != nil {
+		return nil, err
 	}
 
 	return res, nil
 }
+
+// send sends packets of size from src to dst until ctx is done, one every
+// interval or as fast as possible if interval is zero.
+func send(ctx context.Context, src, dst *derpConn, size int, interval time.Duration, done func(error) (bool, error)) error {
+	dstKey := dst.SelfPublicKey()
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return errors.Errorf("generate packet: %w", err)
+	}
+	var ticker *time.Ticker
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+	for seq := uint64(0); ctx.Err() == nil; seq++ {
+		// marshal the timestamp and sequence number into the header
+		binary.LittleEndian.PutUint64(buf[timestampOffset:], uint64(time.Now().UnixNano()))
+		binary.LittleEndian.PutUint64(buf[sequenceOffset:], seq)
+
+		if err := src.Send(dstKey, buf); err != nil {
+			if ok, err := done(err); ok {
+				return err
+			}
+			return classifyConnError("send packet", err)
+		}
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+	}
+	return nil
+}
+
+// recv receives packets of size on c until ctx is done and calls fn with the
+// header of each.
+func recv(ctx context.Context, c *derpConn, size int, done func(error) (bool, error), fn func(timestamp int64, seq uint64)) error {
+	for ctx.Err() == nil {
+		pkt, err := c.Recv()
+		if err != nil {
+			if ok, err := done(err); ok {
+				return err
+			}
+			return classifyConnError("receive packet", err)
+		}
+		if _, ok := pkt.(derp.KeepAliveMessage); ok {
+			continue
+		}
+		p, ok := pkt.(derp.ReceivedPacket)
+		if !ok {
+			return &ProtocolError{Msg: fmt.Sprintf("got %T, want ReceivedPacket", pkt)}
+		}
+		if len(p.Data) != size {
+			return &ShortReadError{Got: len(p.Data), Want: size}
+		}
+		// unmarshal the timestamp and sequence number from the header
+		timestamp := int64(binary.LittleEndian.Uint64(p.Data[timestampOffset:]))
+		seq := binary.LittleEndian.Uint64(p.Data[sequenceOffset:headerSize])
+		fn(timestamp, seq)
+	}
+	// the measurement is over, unless it was cancelled
+	_, err := done(nil)
+	return err
+}
@@ -0,0 +1,68 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// newDerpNode starts an in-process DERP server on loopback and returns its
+// node and certificate.
+func newDerpNode(t *testing.T) (*tailcfg.DERPNode, *x509.Certificate) {
+	t.Helper()
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	t.Cleanup(func() { s.Close() })
+	mux := http.NewServeMux()
+	mux.Handle("/derp", derphttp.Handler(s))
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	derpPort, _ := strconv.Atoi(port)
+	node := &tailcfg.DERPNode{
+		Name:             "1a",
+		RegionID:         1,
+		HostName:         host,
+		IPv4:             host,
+		IPv6:             "none",
+		DERPPort:         derpPort,
+		InsecureForTests: true,
+	}
+	return node, ts.Certificate()
+}
+
+func TestCheckDerpProbesAreNotFlooded(t *testing.T) {
+	node, _ := newDerpNode(t)
+	region := &tailcfg.DERPRegion{RegionID: 1, Nodes: []*tailcfg.DERPNode{node}}
+
+	res, err := New().CheckDerp(context.Background(), region, CheckOptions{Duration: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Bps.Value <= 0 {
+		t.Errorf("bandwidth %v, want > 0", res.Bps)
+	}
+	// 2s of probes every 20ms
+	if res.PacketsReceived < 50 {
+		t.Errorf("%d probes received, want at least 50", res.PacketsReceived)
+	}
+	if res.PacketLoss > 0.05 {
+		t.Errorf("packet loss %.2f on loopback, want at most 0.05", res.PacketLoss)
+	}
+	if res.LatencyStats.P50 <= 0 || res.LatencyStats.P50 > res.LatencyStats.Max {
+		t.Errorf("unexpected latency stats %+v", res.LatencyStats)
+	}
+}
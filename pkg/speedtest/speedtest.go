@@ -1,19 +1,21 @@
 package speedtest
 
 import (
-	"fmt"
+	"cmp"
+	"context"
 	"time"
 
-	"github.com/go-errors/errors"
 	"github.com/yoshino-s/go-framework/application"
 	"go.uber.org/zap"
-	"tailscale.com/derp"
-	"tailscale.com/derp/derphttp"
-	"tailscale.com/net/netmon"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 )
 
+const (
+	DefaultConnectTimeout   = 5 * time.Second
+	DefaultHandshakeTimeout = 5 * time.Second
+)
+
 type SpeedTestService struct {
 	*application.EmptyApplication
 }
@@ -24,45 +26,55 @@ func New() *SpeedTestService {
 	}
 }
 
-func (s *SpeedTestService) CheckDerp(region *tailcfg.DERPRegion, duration time.Duration) (*SpeedTestResult, error) {
-	var err error
-	var m derp.ReceivedMessage
-	logger := s.Logger
-
-	getRegion := func() *tailcfg.DERPRegion {
-		return region
-	}
-
-	priv1 := key.NewNode()
-	priv2 := key.NewNode()
+// CheckOptions are the limits of a check, zero timeouts use the defaults.
+type CheckOptions struct {
+	// ConnectTimeout bounds resolving, the TCP connect and the TLS handshake,
+	// trying the nodes of the region in order.
+	ConnectTimeout time.Duration
+	// HandshakeTimeout bounds the HTTP upgrade, the DERP handshake and the
+	// ServerInfoMessage.
+	HandshakeTimeout time.Duration
+	// Duration is how long packets are sent.
+	Duration time.Duration
+}
 
-	c1 := derphttp.NewRegionClient(priv1, logger.Sugar().Debugf, netmon.NewStatic(), getRegion)
-	c2 := derphttp.NewRegionClient(priv2, logger.Sugar().Debugf, netmon.NewStatic(), getRegion)
+// CheckDerp connects two clients to region and measures the packets relayed
+// between them. Both connections are closed as soon as ctx is done.
+//
+// The result is also returned along with an error, holding the timing of
+// the connection phases which ran.
+func (s *SpeedTestService) CheckDerp(ctx context.Context, region *tailcfg.DERPRegion, opts CheckOptions) (*SpeedTestResult, error) {
+	logger := s.Logger
+	connectTimeout := cmp.Or(opts.ConnectTimeout, DefaultConnectTimeout)
+	handshakeTimeout := cmp.Or(opts.HandshakeTimeout, DefaultHandshakeTimeout)
+	var timing Timing
 
+	logf := logger.Sugar().Debugf
+	c1 := newDerpConn(region, key.NewNode(), logf)
 	defer c1.Close()
+	c2 := newDerpConn(region, key.NewNode(), logf)
 	defer c2.Close()
+	// closing the clients aborts connecting and the measurement at once
+	stop := context.AfterFunc(ctx, func() {
+		c1.Close()
+		c2.Close()
+	})
+	defer stop()
 
-	c2.NotePreferred(true) // just to open it
-
-	m, err = c2.Recv()
-	if err != nil {
-		return nil, err
+	if err := c1.connect(ctx, connectTimeout, handshakeTimeout, &timing.Sender); err != nil {
+		return &SpeedTestResult{Timing: timing}, err
 	}
-	info, ok := m.(derp.ServerInfoMessage)
-	if !ok {
-		return nil, errors.Errorf("got %T, want derp.ServerInfoMessage", m)
+	logger.Debug("c1 got ServerInfoMessage", zap.Any("info", c1.info), zap.Stringer("timing", timing.Sender))
+
+	if err := c2.connect(ctx, connectTimeout, handshakeTimeout, &timing.Receiver); err != nil {
+		return &SpeedTestResult{Timing: timing}, err
 	}
-	logger.Debug("c1 got ServerInfoMessage", zap.Any("info", info))
+	logger.Debug("c2 got ServerInfoMessage", zap.Any("info", c2.info), zap.Stringer("timing", timing.Receiver))
 
-	m, err = c1.Recv()
+	res, err := s.measure(ctx, c1, c2, opts.Duration)
 	if err != nil {
-		return nil, err
-	}
-	info, ok = m.(derp.ServerInfoMessage)
-	if !ok {
-		return nil, fmt.Errorf("got %T, want derp.ServerInfoMessage", m)
+		return &SpeedTestResult{Timing: timing}, err
 	}
-	logger.Debug("c2 got ServerInfoMessage", zap.Any("info", info))
-
-	return s.measure(c1, c2, priv2.Public(), duration)
+	res.Timing = timing
+	return res, nil
 }
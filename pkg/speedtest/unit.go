@@ -25,6 +25,9 @@ func (b Unit) String() string {
 
 func ParseUnit(s string, unit string) (Unit, error) {
 	s = strings.TrimSuffix(s, unit)
+	if s == "" {
+		return Unit{}, fmt.Errorf("invalid unit value %q", s+unit)
+	}
 	// get last char
 	c := s[len(s)-1]
 	var f float64 = 1
@@ -66,7 +69,12 @@ func (b *Unit) UnmarshalText(data []byte) error {
 }
 func (b *Unit) UnmarshalJSON(data []byte) error {
 	s := strings.Trim(string(data), `"`)
-	u, err := ParseUnit(s, b.Uint)
+	unit := b.Uint
+	if unit == "" {
+		// recover the unit from the marshaled value, e.g. "1.50Mbps"
+		unit = strings.TrimLeft(strings.TrimLeft(s, "0123456789.+-"), "KMG")
+	}
+	u, err := ParseUnit(s, unit)
 	if err != nil {
 		return err
 	}